@@ -0,0 +1,528 @@
+package but
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// But prints messages to its own writer, which allows a library to report
+// messages without modifying the package-level Output. Settings other than the
+// writer, such as Format and Strict, are shared with the package.
+//
+// The methods of But correspond to the package-level printing functions. The
+// zero value prints messages in the same manner as those functions.
+type But struct {
+	// Output is the writer to which messages are printed. If nil, messages
+	// are printed to the package-level Output, or to the writers set by
+	// SetLevelOutput, FatalOutput, and SetSink.
+	Output io.Writer
+}
+
+// std is used by the package-level functions.
+var std But
+
+// SetOutput sets Output to w. If w is nil, messages are printed to os.Stderr.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	Output = w
+}
+
+// discarded returns whether messages of the given level would only be written
+// to io.Discard, and therefore do not need to be formatted. This is never the
+// case for messages that may cause an exit, which need their message
+// regardless.
+func (b *But) discarded(level Level) bool {
+	if level == LevelFatal || Strict {
+		return false
+	}
+	if b.Output != nil {
+		return b.Output == io.Discard
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if sink != nil {
+		return false
+	}
+	for _, w := range writers(level) {
+		if w != io.Discard {
+			return false
+		}
+	}
+	return true
+}
+
+// writeln prints args as a message of the given level, in the manner of
+// fmt.Println. Returns the message.
+func (b *But) writeln(level Level, args ...interface{}) string {
+	if b.discarded(level) {
+		return ""
+	}
+	s := encode(level, fmt.Sprintln(args...))
+	writeTo(b.Output, level, s)
+	return s
+}
+
+// writef prints args as a message of the given level, according to format, in
+// the manner of fmt.Printf. Returns the message.
+func (b *But) writef(level Level, format string, args ...interface{}) string {
+	if b.discarded(level) {
+		return ""
+	}
+	s := encode(level, fmt.Sprintf(format, args...))
+	writeTo(b.Output, level, s)
+	return s
+}
+
+// writeErrorf prints err as a message of the given level. In FormatText, the
+// message is args formatted according to format. Returns the message.
+func (b *But) writeErrorf(level Level, err error, format string, args ...interface{}) string {
+	if b.discarded(level) {
+		return ""
+	}
+	s := encodeError(level, err, fmt.Sprintf(format, args...))
+	writeTo(b.Output, level, s)
+	return s
+}
+
+// report prints the reported error err, annotated by ann. If err matches a
+// predicate registered with RegisterFatal, the message is printed as a fatal
+// message, and the program exits.
+func (b *But) report(err error, ann func(error) error) {
+	errorCount.Add(1)
+	printed := ann(short(err))
+	if isFatal(err) {
+		b.die(b.writeErrorf(LevelFatal, printed, "%s", reportText(err, printed, ann)), exitCode(err))
+		return
+	}
+	b.strict(b.writeErrorf(LevelError, printed, "%s", reportText(err, printed, ann)))
+}
+
+// reportText returns the text of printed, the printed form of the reported
+// error err, followed by detail according to the verbosity. At a verbosity of
+// 2 or higher, an err that formats differently with %+v is printed in that
+// form instead, annotated by ann.
+func reportText(err, printed error, ann func(error) error) string {
+	v := Verbosity()
+	if v >= 2 && fmt.Sprintf("%+v", err) != err.Error() {
+		printed = ann(expandedError{err})
+	}
+	text := formatError(printed)
+	stack := debugStack()
+	if v >= 1 && stack == "" {
+		if at := caller(); at != "" {
+			stack = "\tat " + at + "\n"
+		}
+	}
+	return text + "\n" + stack
+}
+
+// expandedError wraps an error, having the message of the error as formatted
+// with %+v.
+type expandedError struct{ err error }
+
+func (e expandedError) Error() string { return fmt.Sprintf("%+v", e.err) }
+
+func (e expandedError) Unwrap() error { return e.err }
+
+// strict exits with msg as the fatal message if Strict is true.
+func (b *But) strict(msg string) {
+	if Strict {
+		b.die(msg, 1)
+	}
+}
+
+// die flushes the writers of b, records msg as the fatal message, runs the
+// functions registered with AtExit, calls OnFatal, and exits with code.
+func (b *But) die(msg string, code int) {
+	b.flush()
+	msg = strings.TrimSuffix(msg, "\n")
+	LastFatal = msg
+	runAtExit()
+	if OnFatal != nil {
+		OnFatal(msg)
+	}
+	Exit(code)
+}
+
+// flush calls the Flush method of each writer of b that has one, so that
+// buffered messages are not lost when exiting.
+func (b *But) flush() {
+	ws := []io.Writer{b.Output}
+	if b.Output == nil {
+		mu.Lock()
+		ws = append(ws, Output, FatalOutput)
+		for _, w := range levelOutputs {
+			ws = append(ws, w)
+		}
+		mu.Unlock()
+	}
+	for _, w := range ws {
+		if f, ok := w.(interface{ Flush() error }); ok {
+			if err := f.Flush(); err != nil && OnWriteError != nil {
+				OnWriteError(err)
+			}
+		}
+	}
+}
+
+// IfError prints err to Output if the error is non-nil. Extra arguments are
+// converted to a string which, if present, annotates the error. Returns true
+// if the error is non-nil.
+func (b *But) IfError(err error, args ...interface{}) bool {
+	return b.IfErrorReturn(err, args...) != nil
+}
+
+// IfErrorReturn prints err to Output if the error is non-nil. Extra arguments
+// are converted to a string which, if present, annotates the error. Returns the
+// annotated error exactly as printed, or nil if err is nil. The exception is
+// when ShortErrors is true, in which case the full error is returned.
+func (b *But) IfErrorReturn(err error, args ...interface{}) error {
+	if err != nil {
+		b.report(err, func(e error) error { return annotate(e, args) })
+		err = annotate(err, args)
+	}
+	return err
+}
+
+// IfErrorf prints err to Output if the err is non-nil. Extra arguments are
+// formatted as a string, according to the format argument. If present, this
+// string annotates the error. Returns true if the error is non-nil.
+func (b *But) IfErrorf(err error, format string, args ...interface{}) bool {
+	if err != nil {
+		b.report(err, func(e error) error { return annotatef(e, format, args) })
+		return true
+	}
+	return false
+}
+
+// IfWarn prints err to Output as a warning if the error is non-nil. Extra
+// arguments are converted to a string which, if present, annotates the error.
+// Returns true if the error is non-nil.
+func (b *But) IfWarn(err error, args ...interface{}) bool {
+	if err != nil {
+		warnCount.Add(1)
+		err = annotate(err, args)
+		b.strict(b.writeErrorf(LevelWarn, err, "warning: %s\n", formatError(err)))
+		return true
+	}
+	return false
+}
+
+// IfWarnf prints err to Output as a warning if the err is non-nil. Extra
+// arguments are formatted as a string, according to the format argument. If
+// present, this string annotates the error. Returns true if the error is
+// non-nil.
+func (b *But) IfWarnf(err error, format string, args ...interface{}) bool {
+	if err != nil {
+		warnCount.Add(1)
+		err = annotatef(err, format, args)
+		b.strict(b.writeErrorf(LevelWarn, err, "warning: %s\n", formatError(err)))
+		return true
+	}
+	return false
+}
+
+// IfFatal prints err as a fatal message and exits, if the error is non-nil.
+// Extra arguments are converted to a string which, if present, annotates the
+// error. The message is completely written before exiting. If err, or any
+// error it wraps, has an ExitCode method, the program exits with the returned
+// code instead of 1.
+func (b *But) IfFatal(err error, args ...interface{}) {
+	if err != nil {
+		err = annotate(err, args)
+		b.die(b.writeErrorf(LevelFatal, err, "%s\n", formatError(err)), exitCode(err))
+	}
+}
+
+// IfFatalf prints err as a fatal message and exits, if the err is non-nil.
+// Extra arguments are formatted as a string, according to the format argument.
+// If present, this string annotates the error. The message is completely
+// written before exiting. The exit code is determined in the same manner as
+// IfFatal.
+func (b *But) IfFatalf(err error, format string, args ...interface{}) {
+	if err != nil {
+		err = annotatef(err, format, args)
+		b.die(b.writeErrorf(LevelFatal, err, "%s\n", formatError(err)), exitCode(err))
+	}
+}
+
+// Log prints the given arguments to Output.
+func (b *But) Log(args ...interface{}) {
+	b.writeln(LevelInfo, args...)
+}
+
+// Logf formats the arguments according to format, and prints the result to
+// Output.
+func (b *But) Logf(format string, args ...interface{}) {
+	b.writef(LevelInfo, format, args...)
+}
+
+// Fatal prints the given arguments as a fatal message and exits.
+func (b *But) Fatal(args ...interface{}) {
+	b.die(b.writeln(LevelFatal, args...), 1)
+}
+
+// Fatalf formats the arguments according to format, prints the result as a
+// fatal message, and exits.
+func (b *But) Fatalf(format string, args ...interface{}) {
+	b.die(b.writef(LevelFatal, format, args...), 1)
+}
+
+// IfErrorDo runs cleanup and then prints err to Output, if the error is
+// non-nil. This allows a failure to be reacted to, such as by removing a
+// partially written file, at the same place it is checked. Extra arguments
+// are converted to a string which, if present, annotates the error. Returns
+// true if the error is non-nil.
+func (b *But) IfErrorDo(err error, cleanup func(), args ...interface{}) bool {
+	if err == nil {
+		return false
+	}
+	cleanup()
+	return b.IfError(err, args...)
+}
+
+// IfErrorCtx prints err to Output if the error is non-nil. The error is
+// annotated with ctx, followed by kv as a bracketed list of key-value pairs:
+//
+//	but.IfErrorCtx(err, "fetch", "url", u, "attempt", 3)
+//	// fetch [url=http://example.com attempt=3]: connection refused
+//
+// Unlike IfErrorf, the annotation does not use format verbs, so its structure is
+// the same regardless of the values. A final key without a value is given the
+// value "(MISSING)". Returns true if the error is non-nil.
+func (b *But) IfErrorCtx(err error, ctx string, kv ...interface{}) bool {
+	if err == nil {
+		return false
+	}
+	if len(kv) > 0 {
+		pairs := make([]string, 0, (len(kv)+1)/2)
+		for i := 0; i < len(kv); i += 2 {
+			if i+1 < len(kv) {
+				pairs = append(pairs, fmt.Sprintf("%v=%v", kv[i], kv[i+1]))
+			} else {
+				pairs = append(pairs, fmt.Sprintf("%v=(MISSING)", kv[i]))
+			}
+		}
+		ctx = strings.TrimPrefix(ctx+" ["+strings.Join(pairs, " ")+"]", " ")
+	}
+	if ctx == "" {
+		return b.IfError(err)
+	}
+	return b.IfError(err, ctx)
+}
+
+// IfNotIs prints err to Output if the error is non-nil, but does not match
+// target according to errors.Is. This reports an unexpected error at a
+// boundary where only target is tolerated. The message includes target. Extra
+// arguments are converted to a string which, if present, annotates the error.
+// Returns true if the error was printed.
+func (b *But) IfNotIs(err, target error, args ...interface{}) bool {
+	if err == nil || errors.Is(err, target) {
+		return false
+	}
+	ann := "unexpected error (expected " + formatError(target) + ")"
+	if len(args) > 0 {
+		ann = fmt.Sprint(args...) + ": " + ann
+	}
+	return b.IfError(err, ann)
+}
+
+// IfDeadline prints the error of ctx to Output if ctx is done, either by being
+// canceled or by exceeding its deadline. If ctx has a deadline, the message
+// includes how long ago the deadline was exceeded. Extra arguments are
+// converted to a string which, if present, annotates the error. Returns true if
+// ctx is done.
+func (b *But) IfDeadline(ctx context.Context, args ...interface{}) bool {
+	err := ctx.Err()
+	if err == nil {
+		return false
+	}
+	if d, ok := ctx.Deadline(); ok && errors.Is(err, context.DeadlineExceeded) {
+		err = fmt.Errorf("%w (by %v)", err, time.Since(d).Round(time.Millisecond))
+	}
+	return b.IfError(err, args...)
+}
+
+// IfErrorUnlessCanceled behaves like IfError, except that nothing is printed and
+// false is returned if ctx is done and err is context.Canceled or
+// context.DeadlineExceeded. Such errors are expected during shutdown, and
+// would otherwise produce noise.
+func (b *But) IfErrorUnlessCanceled(ctx context.Context, err error, args ...interface{}) bool {
+	if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return false
+	}
+	return b.IfError(err, args...)
+}
+
+// Summary prints the number of errors and warnings reported by IfError,
+// IfWarn, and their variants, such as "3 errors, 1 warning". Nothing is printed
+// if no errors or warnings were reported. Summary is intended to be deferred at
+// the start of main:
+//
+//	defer but.Summary()
+func (b *But) Summary() {
+	var s []string
+	if n := errorCount.Load(); n > 0 {
+		s = append(s, plural(n, "error"))
+	}
+	if n := warnCount.Load(); n > 0 {
+		s = append(s, plural(n, "warning"))
+	}
+	if len(s) > 0 {
+		b.writeln(LevelInfo, strings.Join(s, ", "))
+	}
+}
+
+// MustNot prints err to Output and panics, if the error is non-nil. Extra
+// arguments are converted to a string which, if present, annotates the error.
+// The annotated error is used as the panic value. Unlike IfFatal, the program
+// does not exit cleanly; the panic exposes the full stack, and allows deferred
+// functions to recover. This is intended for errors that indicate a bug.
+func (b *But) MustNot(err error, args ...interface{}) {
+	if err != nil {
+		err = annotate(err, args)
+		b.writeErrorf(LevelError, err, "%s\n", formatError(err))
+		panic(err)
+	}
+}
+
+// Report prints err to Output if the error is non-nil, and returns code.
+// Extra arguments are converted to a string which, if present, annotates the
+// error. Returns 0 if the error is nil. Unlike IfFatal, Report does not exit,
+// leaving that to the caller:
+//
+//	os.Exit(but.Report(2, err, "usage"))
+func (b *But) Report(code int, err error, args ...interface{}) int {
+	if b.IfError(err, args...) {
+		return code
+	}
+	return 0
+}
+
+// LogTable prints each row to Output as a line containing two columns: a key
+// and a message. The messages are aligned according to the widest key, up to
+// TableKeyWidth.
+func (b *But) LogTable(rows [][2]string) {
+	width := 0
+	for _, row := range rows {
+		if n := utf8.RuneCountInString(row[0]); n > width {
+			width = n
+		}
+	}
+	if TableKeyWidth > 0 && width > TableKeyWidth {
+		width = TableKeyWidth
+	}
+	for _, row := range rows {
+		key := []rune(row[0])
+		if len(key) > width {
+			key = append(key[:width-1], '…')
+		}
+		b.writeln(LevelInfo, string(key)+strings.Repeat(" ", width-len(key))+"  "+row[1])
+	}
+}
+
+// Timed returns a function that prints the given arguments to Output, followed
+// by the time elapsed since Timed was called. It is intended to be deferred:
+//
+//	defer but.Timed("build")()
+//	// build took 1.2s
+//
+// The elapsed time is measured when the returned function is called.
+func (b *But) Timed(args ...interface{}) func() {
+	start := time.Now()
+	return func() {
+		d := time.Since(start)
+		if d >= time.Millisecond {
+			d = d.Round(time.Millisecond)
+		}
+		b.writeln(LevelInfo, fmt.Sprint(args...), "took", d)
+	}
+}
+
+// Would prints the given arguments to Output, preceded by "[dry-run]", if
+// DryRun is true. Otherwise, nothing is printed.
+func (b *But) Would(args ...interface{}) {
+	if DryRun {
+		b.writeln(LevelInfo, append([]interface{}{"[dry-run]"}, args...)...)
+	}
+}
+
+// Vlog prints the given arguments to Output at LevelDebug, if the verbosity is
+// at least n.
+func (b *But) Vlog(n int, args ...interface{}) {
+	if V(n) {
+		b.writeln(LevelDebug, args...)
+	}
+}
+
+// Debug prints the given arguments to Output at LevelDebug, if the verbosity is
+// at least 1.
+func (b *But) Debug(args ...interface{}) {
+	if V(1) {
+		b.writeln(LevelDebug, args...)
+	}
+}
+
+// Debugf formats the arguments according to format, and prints the result to
+// Output at LevelDebug, if the verbosity is at least 1. The arguments are not
+// formatted otherwise.
+func (b *But) Debugf(format string, args ...interface{}) {
+	if V(1) {
+		b.writef(LevelDebug, format, args...)
+	}
+}
+
+// Trace prints the given arguments to Output at LevelDebug, if the verbosity is
+// at least 2.
+func (b *But) Trace(args ...interface{}) {
+	if V(2) {
+		b.writeln(LevelDebug, args...)
+	}
+}
+
+// Tracef formats the arguments according to format, and prints the result to
+// Output at LevelDebug, if the verbosity is at least 2. The arguments are not
+// formatted otherwise.
+func (b *But) Tracef(format string, args ...interface{}) {
+	if V(2) {
+		b.writef(LevelDebug, format, args...)
+	}
+}
+
+// Validate prints each failed check as a fatal message and exits, if any
+// checks failed. All failures are reported together, rather than only the
+// first.
+func (b *But) Validate(checks ...Check) {
+	if err := ValidateErr(checks...); err != nil {
+		b.die(b.writeErrorf(LevelFatal, err, "%s\n", formatError(err)), 1)
+	}
+}
+
+// Guard runs fn. If fn panics, the recovered value is printed as a fatal
+// message, and the program exits. A value that is an error, such as an Errors,
+// is displayed in the same way as a reported error. If FatalStack is true, the
+// stack of the panic is printed after the value. Guard is intended to wrap the
+// body of main or of a command handler:
+//
+//	but.Guard(func() {
+//		...
+//	})
+func (b *But) Guard(fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if FatalStack {
+				b.die(b.writef(LevelFatal, "panic: %s\n\n%s", panicString(r), debug.Stack()), 1)
+			} else {
+				b.die(b.writeln(LevelFatal, "panic:", panicString(r)), 1)
+			}
+		}
+	}()
+	fn()
+}
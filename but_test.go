@@ -0,0 +1,119 @@
+package but
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestVerboseExpanded(t *testing.T) {
+	out, _ := capture(t)
+	prevFormatter, prevShort := ErrorFormatter, ShortErrors
+	t.Cleanup(func() {
+		SetVerbosity(0)
+		ErrorFormatter, ShortErrors = prevFormatter, prevShort
+	})
+	err := detailError{"boom"}
+
+	IfError(err, "ctx")
+	if got, want := firstLine(out.String()), "ctx: boom"; got != want {
+		t.Errorf("verbosity 0: got %q, want %q", got, want)
+	}
+
+	SetVerbosity(2)
+	tests := []struct {
+		name      string
+		formatter func(error) string
+		short     bool
+		want      string
+	}{
+		{"default", prevFormatter, false, "ctx 1: boom (detail)"},
+		{"ErrorFormatter", func(e error) string { return "<" + e.Error() + ">" }, false, "<ctx 1: boom (detail)>"},
+		{"ShortErrors", prevFormatter, true, "ctx 1: boom (detail)"},
+	}
+	for _, test := range tests {
+		out.Reset()
+		ErrorFormatter, ShortErrors = test.formatter, test.short
+		IfErrorf(err, "ctx %d", 1)
+		if got := firstLine(out.String()); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestVerboseCaller(t *testing.T) {
+	if debugStack() != "" {
+		t.Skip("debug builds print a stack instead of the caller")
+	}
+	out, _ := capture(t)
+	t.Cleanup(func() { SetVerbosity(0) })
+	SetVerbosity(1)
+
+	_, file, line, _ := runtime.Caller(0)
+	IfError(errors.New("boom"), "ctx")
+	want := fmt.Sprintf("ctx: boom\n\tat %s:%d\n", file, line+1)
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDebugLevels(t *testing.T) {
+	out, _ := capture(t)
+	t.Cleanup(func() { SetVerbosity(0) })
+	print := func() {
+		Debug("debug")
+		Debugf("debugf %d\n", 1)
+		Trace("trace")
+	}
+	tests := []struct {
+		verbosity int
+		want      string
+	}{
+		{0, ""},
+		{1, "debug\ndebugf 1\n"},
+		{2, "debug\ndebugf 1\ntrace\n"},
+	}
+	for _, test := range tests {
+		out.Reset()
+		SetVerbosity(test.verbosity)
+		print()
+		if got := out.String(); got != test.want {
+			t.Errorf("verbosity %d: got %q, want %q", test.verbosity, got, test.want)
+		}
+	}
+}
+
+func TestButOutput(t *testing.T) {
+	out, exit := capture(t)
+	t.Cleanup(func() { SetVerbosity(0) })
+	SetVerbosity(1)
+	var buf bytes.Buffer
+	b := &But{Output: &buf}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.IfErrorCtx(errors.New("refused"), "fetch", "attempt", 3)
+	b.IfErrorDo(errors.New("write"), func() {}, "save")
+	b.IfErrorUnlessCanceled(ctx, context.Canceled)
+	b.Report(2, errors.New("usage"))
+	b.Debugf("debug %d\n", 1)
+	b.Would("delete")
+	b.Guard(func() { panic("oops") })
+
+	got := buf.String()
+	for _, line := range []string{"fetch [attempt=3]: refused", "save: write", "usage", "debug 1", "panic: oops"} {
+		if !strings.Contains(got, line+"\n") {
+			t.Errorf("missing %q in %q", line, got)
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("printed to Output: %q", out.String())
+	}
+	if *exit != 1 {
+		t.Errorf("Guard: exit code %d, want 1", *exit)
+	}
+}
@@ -0,0 +1,67 @@
+package but
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCollectorGo(t *testing.T) {
+	const n = 400
+	c := NewCollector("processing")
+	for i := 0; i < n; i++ {
+		c.Go(func() error {
+			if i%2 == 0 {
+				return nil
+			}
+			return fmt.Errorf("item %d", i)
+		})
+		c.Go(func() error {
+			c.Add(nil)
+			return nil
+		})
+	}
+	err := c.Wait()
+	if got := c.Len(); got != n/2 {
+		t.Errorf("Len: got %d, want %d", got, n/2)
+	}
+	agg, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("Wait: got %T, want Errors", err)
+	}
+	if agg.Msg != "processing" || len(agg.Errs) != n/2 {
+		t.Errorf("Wait: got Msg %q with %d errors", agg.Msg, len(agg.Errs))
+	}
+}
+
+func TestCollectorAdd(t *testing.T) {
+	c := NewCollector("msg")
+	c.Add(nil)
+	if err := c.Err(); err != nil {
+		t.Errorf("no errors: got %v", err)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Add(nil): Len is %d", c.Len())
+	}
+
+	a := errors.New("a")
+	c.Add(a)
+	if err := c.Err(); err != a {
+		t.Errorf("one error: got %#v, want the error itself", err)
+	}
+
+	b, d := errors.New("b"), errors.New("d")
+	c.Add(b)
+	c.Add(nil)
+	c.Add(d)
+	if c.Len() != 3 {
+		t.Errorf("Len: got %d, want 3", c.Len())
+	}
+	agg, ok := c.Err().(Errors)
+	if !ok {
+		t.Fatalf("got %T, want Errors", c.Err())
+	}
+	if agg.Msg != "msg" || fmt.Sprint(agg.Errs) != fmt.Sprint([]error{a, b, d}) {
+		t.Errorf("got %q %v, want insertion order", agg.Msg, agg.Errs)
+	}
+}
@@ -0,0 +1,76 @@
+package but
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// Input is the reader from which Confirm reads responses. Defaults to
+// os.Stdin.
+var Input io.Reader = os.Stdin
+
+// AssumeYes indicates whether Confirm returns true without prompting, as with a
+// --yes flag.
+var AssumeYes bool
+
+// Confirm prints prompt to Output, followed by " [y/N] ", and reads a line
+// from Input. The prompt is always printed as text, even when Format is not
+// FormatText or a sink is set, since it is meant to be read by a person.
+// Returns true if the response is "y" or "yes", ignoring case. Returns false
+// for any other response, or if Input is at EOF. If AssumeYes is true, then
+// Confirm returns true without prompting.
+func Confirm(prompt string) bool {
+	if AssumeYes {
+		return true
+	}
+	writePrompt(prompt + " [y/N] ")
+	line, err := readLine(Input)
+	if line == "" && err != nil {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// writePrompt writes the prompt s to the writers of LevelInfo. Unlike other
+// messages, s is never encoded or passed to a sink.
+func writePrompt(s string) {
+	var errs []error
+	orig := origin()
+	mu.Lock()
+	b, _ := appendMessage(nil, LevelInfo, orig, s)
+	for _, w := range writers(LevelInfo) {
+		if _, err := w.Write(b); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	mu.Unlock()
+	if OnWriteError != nil {
+		for _, err := range errs {
+			OnWriteError(err)
+		}
+	}
+}
+
+// readLine reads from r up to and excluding the next newline. Bytes are read
+// one at a time, so that nothing beyond the line is consumed from r.
+func readLine(r io.Reader) (string, error) {
+	var b strings.Builder
+	var c [1]byte
+	for {
+		n, err := r.Read(c[:])
+		if n > 0 {
+			if c[0] == '\n' {
+				return b.String(), nil
+			}
+			b.WriteByte(c[0])
+		}
+		if err != nil {
+			return b.String(), err
+		}
+	}
+}
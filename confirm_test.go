@@ -0,0 +1,26 @@
+package but
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfirmPrompt(t *testing.T) {
+	out, _ := capture(t)
+	prevInput, prevFormat := Input, Format
+	t.Cleanup(func() {
+		Input = prevInput
+		SetFormat(prevFormat)
+	})
+	for _, f := range []OutputFormat{FormatText, FormatJSON, FormatProblem} {
+		out.Reset()
+		SetFormat(f)
+		Input = strings.NewReader("yes\n")
+		if !Confirm("Delete?") {
+			t.Errorf("format %d: got false, want true", f)
+		}
+		if got, want := out.String(), "Delete? [y/N] "; got != want {
+			t.Errorf("format %d: printed %q, want %q", f, got, want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+//go:build debug
+
+package but
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+// This file is included only in debug builds. See the package documentation.
+
+// debugFrames is the maximum number of frames included by debugStack.
+const debugFrames = 8
+
+// debugStack returns a short stack trace, excluding frames within this package
+// and the runtime. Each frame is written on its own indented line.
+func debugStack() string {
+	pc := make([]uintptr, debugFrames+8)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	var b strings.Builder
+	for n := 0; n < debugFrames; {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/anaminus/but.") &&
+			!strings.HasPrefix(frame.Function, "runtime.") {
+			fmt.Fprintf(&b, "\tat %s (%s:%d)\n", frame.Function, frame.File, frame.Line)
+			n++
+		}
+		if !more {
+			break
+		}
+	}
+	return b.String()
+}
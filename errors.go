@@ -1,81 +1,1009 @@
 // The but package provides helpers for handling messages while at the bottom
 // of the call stack.
+//
+// Building with the debug tag produces a debug build, in which errors printed
+// by IfError and its variants are followed by a short stack trace of the
+// caller:
+//
+//	go build -tags debug
+//
+// Builds without the tag print errors as usual, at no extra cost.
 package but
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"iter"
 	"os"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
 )
 
-// IfError prints err to stderr if the error is non-nil. Extra arguments are
+// Output is the writer to which all messages are printed, except for levels
+// given a writer with SetLevelOutput. Defaults to os.Stderr. If nil, messages
+// are printed to os.Stderr. Use SetOutput to change Output while messages may
+// be printed concurrently.
+var Output io.Writer = os.Stderr
+
+// OnWriteError, if non-nil, is called with the error returned by a failed write
+// to Output. This can be used to report failures of outputs that aren't
+// files, or to fall back to another writer. By default, write errors are
+// ignored.
+var OnWriteError func(error)
+
+// UseStdout sets Output to os.Stdout. Fatal functions still exit with a
+// non-zero status, regardless of the stream.
+func UseStdout() {
+	mu.Lock()
+	defer mu.Unlock()
+	Output = os.Stdout
+}
+
+// UseStderr sets Output to os.Stderr, which is the default.
+func UseStderr() {
+	mu.Lock()
+	defer mu.Unlock()
+	Output = os.Stderr
+}
+
+// Discard sets Output to io.Discard, and returns the previous value of Output
+// so that it can be restored. This is useful for benchmarking code paths that
+// print messages. Messages that are discarded are not formatted.
+func Discard() io.Writer {
+	mu.Lock()
+	defer mu.Unlock()
+	w := Output
+	Output = io.Discard
+	return w
+}
+
+// TestWriter returns a writer that passes each line written to it to t.Log,
+// which is usually a *testing.T. Setting Output to such a writer associates
+// messages with the running test:
+//
+//	but.SetOutput(but.TestWriter(t))
+//
+// Each call to Write is treated as ending with a newline. An empty write is
+// ignored.
+func TestWriter(t interface{ Log(...interface{}) }) io.Writer {
+	return testWriter{t: t}
+}
+
+type testWriter struct {
+	t interface{ Log(...interface{}) }
+}
+
+func (w testWriter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.t.Log(line)
+	}
+	return len(p), nil
+}
+
+// Strict indicates whether all reported errors and warnings are fatal. When
+// true, IfError, IfWarn, and their variants exit after printing. Return values
+// are unchanged, though the program exits before a caller can see the result
+// of a non-nil error.
+var Strict bool
+
+// FatalOutput, if non-nil, is the writer to which fatal messages are printed
+// instead of Output. This ensures that a fatal message is visible even when
+// Output is redirected to a quiet destination.
+var FatalOutput io.Writer
+
+// FatalTee indicates whether fatal messages are also printed to Output when
+// FatalOutput is set.
+var FatalTee bool
+
+// LastFatal is the most recent fatal message, which is set just before
+// exiting.
+var LastFatal string
+
+// OnFatal, if non-nil, is called with the fatal message just before exiting.
+// This allows the message to be passed to a crash reporter, for example. When a
+// fatal function is called, the following occurs in order:
+//
+//  1. The message is printed.
+//  2. LastFatal is set to the message, without the trailing newline.
+//  3. The functions registered with AtExit are run.
+//  4. OnFatal is called with the message.
+//  5. Exit is called.
+//
+// This also applies to errors made fatal by Strict.
+var OnFatal func(msg string)
+
+// FatalStack indicates whether Guard includes the stack of a recovered panic in
+// its message.
+var FatalStack bool
+
+// mu guards writes to Output, as well as the prefix stack.
+var mu sync.Mutex
+
+// prefixes is the stack of prefixes pushed by PushPrefix.
+var prefixes []string
+
+// PushPrefix appends p to a stack of prefixes, which are printed in order at
+// the start of each message. Returns a function that pops the prefix off the
+// stack, which is intended to be deferred:
+//
+//	defer but.PushPrefix("build: ")()
+//
+// The stack is global rather than goroutine-local, so concurrent operations
+// will see each other's prefixes.
+func PushPrefix(p string) func() {
+	mu.Lock()
+	defer mu.Unlock()
+	n := len(prefixes)
+	prefixes = append(prefixes, p)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if n < len(prefixes) {
+			prefixes = prefixes[:n]
+		}
+	}
+}
+
+// SanitizeControl indicates whether control characters are escaped in all
+// printed messages. This prevents a message derived from untrusted input from
+// injecting terminal escape sequences, or from overwriting or faking lines.
+//
+// Affected characters are those for which unicode.IsControl returns true:
+// U+0000 to U+001F, U+007F, and U+0080 to U+009F, except for newline and tab.
+// Each is written as a \x escape, such that ESC becomes "\x1b".
+var SanitizeControl = true
+
+// appendSanitized appends s to b, escaping control characters other than
+// newline and tab.
+func appendSanitized(b []byte, s string) []byte {
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if isUnsafe(r) {
+			b = fmt.Appendf(b, "\\x%02x", r)
+		} else {
+			b = append(b, s[i:i+n]...)
+		}
+		i += n
+	}
+	return b
+}
+
+// isUnsafe returns whether r is a control character that is not newline or
+// tab.
+func isUnsafe(r rune) bool {
+	return unicode.IsControl(r) && r != '\n' && r != '\t'
+}
+
+// Level indicates the severity of a message.
+type Level int
+
+const (
+	LevelDebug Level = iota // Printed by Debug, Trace, and Vlog.
+	LevelInfo               // Printed by Log, Logf, and similar.
+	LevelWarn               // Printed by IfWarn and IfWarnf.
+	LevelError              // Printed by IfError and its variants.
+	LevelFatal              // Printed by functions that exit.
+)
+
+// String returns the name of the level.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warning"
+	case LevelError:
+		return "error"
+	case LevelFatal:
+		return "fatal"
+	}
+	return "Level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Sink receives every message printed by this package, along with its level.
+type Sink interface {
+	// Write receives a message of the given level. msg is the complete
+	// message, as it would otherwise be written to Output, including any
+	// prefixes and trailing newline. Write is not called concurrently, and must
+	// not print messages with this package.
+	Write(level Level, msg string)
+}
+
+// sink is the Sink set by SetSink. Guarded by mu.
+var sink Sink
+
+// sinkMu serializes calls to the Write method of sink.
+var sinkMu sync.Mutex
+
+// SetSink routes all messages to s, instead of to Output and the writers set
+// by SetLevelOutput. If s is nil, the default of DefaultSink is restored. FFatal
+// continues to print to its given writer.
+func SetSink(s Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sink = s
+}
+
+// DefaultSink returns the Sink that writes each message to Output and the
+// writers set by SetLevelOutput, FatalOutput, and FatalTee, which is how
+// messages are written when no Sink is set. A custom Sink can forward to it,
+// for example, to record messages while still printing them:
+//
+//	type recorder struct{ msgs []string }
+//
+//	func (r *recorder) Write(level but.Level, msg string) {
+//		r.msgs = append(r.msgs, msg)
+//		but.DefaultSink().Write(level, msg)
+//	}
+//
+// Unlike the writers themselves, the default Sink never colors messages.
+func DefaultSink() Sink {
+	return writerSink{}
+}
+
+// writerSink is the Sink returned by DefaultSink.
+type writerSink struct{}
+
+func (writerSink) Write(level Level, msg string) {
+	var errs []error
+	mu.Lock()
+	for _, w := range writers(level) {
+		if _, err := w.Write([]byte(msg)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	mu.Unlock()
+	if OnWriteError != nil {
+		for _, err := range errs {
+			OnWriteError(err)
+		}
+	}
+}
+
+// levelOutputs maps a level to the writer set by SetLevelOutput. Guarded by
+// mu.
+var levelOutputs = map[Level]io.Writer{}
+
+// SetLevelOutput sets the writer to which messages of the given level are
+// printed. If w is nil, the level falls back to Output.
+func SetLevelOutput(level Level, w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	if w == nil {
+		delete(levelOutputs, level)
+		return
+	}
+	levelOutputs[level] = w
+}
+
+// writers returns the writers to which messages of the given level are
+// printed. Must be called while mu is held.
+func writers(level Level) []io.Writer {
+	w := Output
+	if w == nil {
+		w = os.Stderr
+	}
+	if lw, ok := levelOutputs[level]; ok {
+		w = lw
+	}
+	if level == LevelFatal && FatalOutput != nil {
+		if FatalTee {
+			return []io.Writer{FatalOutput, w}
+		}
+		return []io.Writer{FatalOutput}
+	}
+	return []io.Writer{w}
+}
+
+// IncludePID indicates whether each message is preceded by the ID of the
+// process, as "[pid=1234]". This helps correlate messages from forked workers.
+var IncludePID bool
+
+// IncludeCWD indicates whether each message is preceded by the current working
+// directory, as "[cwd=/path]". The directory is determined for each message,
+// since it may change.
+var IncludeCWD bool
+
+// pid is the ID of the process.
+var pid = os.Getpid()
+
+// origin returns the text identifying the origin of a message, according to
+// IncludePID and IncludeCWD.
+func origin() string {
+	var s string
+	if IncludePID {
+		s += "[pid=" + strconv.Itoa(pid) + "] "
+	}
+	if IncludeCWD {
+		cwd, err := os.Getwd()
+		if err != nil {
+			cwd = "?"
+		}
+		s += "[cwd=" + cwd + "] "
+	}
+	return s
+}
+
+// SyncStdout indicates whether the functions registered with
+// RegisterStdoutFlusher are called before each message is written. When a
+// program buffers its own output to stdout, this keeps that output ordered
+// with messages printed to stderr.
+var SyncStdout bool
+
+// stdoutFlushers contains the functions registered with
+// RegisterStdoutFlusher. Guarded by mu.
+var stdoutFlushers []func() error
+
+// RegisterStdoutFlusher registers a function that flushes a buffer of stdout,
+// such as the Flush method of a bufio.Writer, to be called before each message
+// is written when SyncStdout is true. An error returned by flush is passed to
+// OnWriteError.
+func RegisterStdoutFlusher(flush func() error) {
+	mu.Lock()
+	defer mu.Unlock()
+	stdoutFlushers = append(stdoutFlushers, flush)
+}
+
+// buffers pools the buffers used to compose messages.
+var buffers = sync.Pool{New: func() interface{} { return new([]byte) }}
+
+// appendMessage appends the complete form of s to b, as it will be written,
+// preceded by the decoration of the given level and orig. Returns the index
+// within b of the severity tag, or -1 if there is none. Must be called while mu
+// is held.
+func appendMessage(b []byte, level Level, orig, s string) ([]byte, int) {
+	add := func(b []byte, s string) []byte { return append(b, s...) }
+	if SanitizeControl {
+		add = appendSanitized
+	}
+	if encoding() {
+		return append(b, s...), -1
+	}
+	start := len(b)
+	b, tag := appendStyle(b, level, add)
+	b = add(b, orig)
+	for _, p := range prefixes {
+		b = add(b, p)
+	}
+	b = add(b, s)
+	if MaxLineWidth > 0 {
+		b = append(b[:start], truncateLines(b[start:], MaxLineWidth)...)
+	}
+	return b, tag
+}
+
+// MaxLineWidth, if greater than zero, is the maximum width of each printed
+// line, in runes. Longer lines are truncated, ending with "…". The width
+// includes everything preceding a message, such as prefixes.
+var MaxLineWidth int
+
+// truncateLines returns a copy of b where each line is truncated to width
+// runes.
+func truncateLines(b []byte, width int) []byte {
+	t := make([]byte, 0, len(b))
+	for len(b) > 0 {
+		line := b
+		i := bytes.IndexByte(b, '\n')
+		if i >= 0 {
+			line, b = b[:i+1], b[i+1:]
+		} else {
+			b = nil
+		}
+		body := bytes.TrimSuffix(line, []byte("\n"))
+		if utf8.RuneCount(body) <= width {
+			t = append(t, line...)
+			continue
+		}
+		for n := 0; n < width-1; n++ {
+			_, size := utf8.DecodeRune(body)
+			t = append(t, body[:size]...)
+			body = body[size:]
+		}
+		t = append(t, "…"...)
+		if i >= 0 {
+			t = append(t, '\n')
+		}
+	}
+	return t
+}
+
+// write prints s as a message of the given level, preceded by the current
+// prefixes. The message is composed into a single buffer, and is written to
+// each writer with one call to Write, so that messages are less likely to be
+// torn when multiple processes share a writer. Writes are synchronized across
+// all writers.
+func write(level Level, s string) {
+	writeTo(nil, level, s)
+}
+
+// writeTo prints s in the same manner as write, but to w instead of the
+// writers of the level, if w is non-nil.
+func writeTo(w io.Writer, level Level, s string) {
+	var errs []error
+	orig := origin()
+	bp := buffers.Get().(*[]byte)
+	mu.Lock()
+	if SyncStdout {
+		for _, flush := range stdoutFlushers {
+			if err := flush(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	b, tag := appendMessage((*bp)[:0], level, orig, s)
+	var ws []io.Writer
+	var sk Sink
+	switch {
+	case w != nil:
+		ws = []io.Writer{w}
+	case sink != nil:
+		sk = sink
+	default:
+		ws = writers(level)
+	}
+	for _, w := range ws {
+		if _, err := w.Write(colored(w, b, level, tag)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	mu.Unlock()
+	if sk != nil {
+		sinkMu.Lock()
+		sk.Write(level, string(b))
+		sinkMu.Unlock()
+	}
+	if cap(b) <= 1<<16 {
+		*bp = b
+		buffers.Put(bp)
+	}
+	if OnWriteError != nil {
+		for _, err := range errs {
+			OnWriteError(err)
+		}
+	}
+}
+
+// IfError prints err to Output if the error is non-nil. Extra arguments are
 // converted to a string which, if present, annotates the error. Returns true
 // if the error is non-nil.
 func IfError(err error, args ...interface{}) bool {
-	if err != nil {
-		if len(args) > 0 {
-			err = fmt.Errorf(fmt.Sprint(args...)+": %w", err)
+	return std.IfError(err, args...)
+}
+
+// IfErrorReturn prints err to Output if the error is non-nil. Extra arguments
+// are converted to a string which, if present, annotates the error. Returns the
+// annotated error exactly as printed, or nil if err is nil. The exception is
+// when ShortErrors is true, in which case the full error is returned. This
+// allows an error to be reported and propagated at once:
+//
+//	return but.IfErrorReturn(err, "loading")
+func IfErrorReturn(err error, args ...interface{}) error {
+	return std.IfErrorReturn(err, args...)
+}
+
+// fatalMu guards fatalPredicates.
+var fatalMu sync.RWMutex
+
+// fatalPredicates contains the predicates registered with RegisterFatal.
+var fatalPredicates []func(error) bool
+
+// RegisterFatal registers a predicate that determines whether an error is
+// always fatal. When IfError or one of its variants reports an error, each
+// predicate is called with the unannotated error, in the order they were
+// registered. If a predicate returns true, the remaining predicates are
+// skipped, and the error is printed as a fatal message, after which the
+// program exits.
+func RegisterFatal(is func(error) bool) {
+	fatalMu.Lock()
+	defer fatalMu.Unlock()
+	fatalPredicates = append(fatalPredicates, is)
+}
+
+// isFatal returns whether err matches a predicate registered with
+// RegisterFatal.
+func isFatal(err error) bool {
+	fatalMu.RLock()
+	defer fatalMu.RUnlock()
+	for _, is := range fatalPredicates {
+		if is(err) {
+			return true
 		}
-		fmt.Fprintln(os.Stderr, err)
-		return true
 	}
 	return false
 }
 
-// IfErrorf prints err to stderr if the err is non-nil. Extra arguments are
+// ShortErrors indicates whether IfError and its variants print only the
+// outermost message of an error, excluding the messages of the errors it
+// wraps. For example, an error created with
+//
+//	fmt.Errorf("open config: %w", err)
+//
+// is printed as "open config". An error that wraps nothing is printed in full.
+// Any annotation is still included. ShortErrors affects only the printed
+// message; the error returned by IfErrorReturn retains the full chain.
+var ShortErrors bool
+
+// short returns err with only its outermost message if ShortErrors is true.
+// Otherwise, err is returned unchanged.
+func short(err error) error {
+	if !ShortErrors {
+		return err
+	}
+	if outer := outerMessage(err); outer != err.Error() {
+		return errors.New(outer)
+	}
+	return err
+}
+
+// outerMessage returns the message of err, excluding the message of the error
+// it wraps, if any.
+func outerMessage(err error) string {
+	msg := err.Error()
+	inner := errors.Unwrap(err)
+	if inner == nil {
+		return msg
+	}
+	outer := strings.TrimRight(strings.TrimSuffix(msg, inner.Error()), ": ")
+	if outer == "" {
+		return msg
+	}
+	return outer
+}
+
+// ErrorFormatter converts an error to a string. It is used everywhere this
+// package displays an error, including the errors within an Errors. This can be
+// used, for example, to apply formatting that includes stack traces. If nil,
+// the result of the error's Error method is used, which is also the default.
+//
+// ErrorFormatter is called for every error that is displayed, including each
+// error of an aggregate, so an expensive formatter will slow down all output.
+var ErrorFormatter = func(e error) string { return e.Error() }
+
+// formatError converts e to a string with ErrorFormatter.
+func formatError(e error) string {
+	if e == nil {
+		return "<nil>"
+	}
+	if ErrorFormatter == nil {
+		return e.Error()
+	}
+	return ErrorFormatter(e)
+}
+
+// AnnotationLayout determines where an annotation is placed relative to the
+// error it annotates.
+type AnnotationLayout int
+
+const (
+	// StylePrefix places the annotation before the error, separated by a
+	// colon:
+	//
+	//	annotation: error
+	StylePrefix AnnotationLayout = iota
+	// StyleBracketSuffix places the annotation after the error, in brackets:
+	//
+	//	error [annotation]
+	StyleBracketSuffix
+)
+
+// AnnotationStyle is the layout of annotations applied by IfError, IfErrorf,
+// and their variants. Defaults to StylePrefix.
+var AnnotationStyle = StylePrefix
+
+// annotate wraps err with the string form of args, if any are present.
+func annotate(err error, args []interface{}) error {
+	if len(args) > 0 {
+		err = annotateString(err, fmt.Sprint(args...))
+	}
+	return err
+}
+
+// annotatef wraps err with args formatted according to format.
+func annotatef(err error, format string, args []interface{}) error {
+	if AnnotationStyle == StyleBracketSuffix {
+		return annotateString(err, fmt.Sprintf(format, args...))
+	}
+	return fmt.Errorf(format+": %w", append(args, err)...)
+}
+
+// annotateString wraps err with s according to AnnotationStyle.
+func annotateString(err error, s string) error {
+	if AnnotationStyle == StyleBracketSuffix {
+		return fmt.Errorf("%w [%s]", err, s)
+	}
+	return fmt.Errorf("%s: %w", s, err)
+}
+
+// IfErrorf prints err to Output if the err is non-nil. Extra arguments are
 // formatted as a string, according to the format argument. If present, this
 // string annotates the error. Returns true if the error is non-nil.
 func IfErrorf(err error, format string, args ...interface{}) bool {
-	if err != nil {
-		args = append(args, err)
-		err = fmt.Errorf(format+": %w", args...)
-		fmt.Fprintln(os.Stderr, err)
-		return true
+	return std.IfErrorf(err, format, args...)
+}
+
+// IfErrorDo runs cleanup and then prints err to Output, if the error is
+// non-nil. This allows a failure to be reacted to, such as by removing a
+// partially written file, at the same place it is checked. Extra arguments
+// are converted to a string which, if present, annotates the error. Returns
+// true if the error is non-nil.
+func IfErrorDo(err error, cleanup func(), args ...interface{}) bool {
+	return std.IfErrorDo(err, cleanup, args...)
+}
+
+// IfErrorCtx prints err to Output if the error is non-nil. The error is
+// annotated with ctx, followed by kv as a bracketed list of key-value pairs:
+//
+//	but.IfErrorCtx(err, "fetch", "url", u, "attempt", 3)
+//	// fetch [url=http://example.com attempt=3]: connection refused
+//
+// Unlike IfErrorf, the annotation does not use format verbs, so its structure is
+// the same regardless of the values. A final key without a value is given the
+// value "(MISSING)". Returns true if the error is non-nil.
+func IfErrorCtx(err error, ctx string, kv ...interface{}) bool {
+	return std.IfErrorCtx(err, ctx, kv...)
+}
+
+// IfNotIs prints err to Output if the error is non-nil, but does not match
+// target according to errors.Is. This reports an unexpected error at a
+// boundary where only target is tolerated. The message includes target. Extra
+// arguments are converted to a string which, if present, annotates the error.
+// Returns true if the error was printed.
+func IfNotIs(err, target error, args ...interface{}) bool {
+	return std.IfNotIs(err, target, args...)
+}
+
+// IfDeadline prints the error of ctx to Output if ctx is done, either by being
+// canceled or by exceeding its deadline. If ctx has a deadline, the message
+// includes how long ago the deadline was exceeded. Extra arguments are
+// converted to a string which, if present, annotates the error. Returns true if
+// ctx is done.
+func IfDeadline(ctx context.Context, args ...interface{}) bool {
+	return std.IfDeadline(ctx, args...)
+}
+
+// IfErrorUnlessCanceled behaves like IfError, except that nothing is printed and
+// false is returned if ctx is done and err is context.Canceled or
+// context.DeadlineExceeded. Such errors are expected during shutdown, and
+// would otherwise produce noise.
+func IfErrorUnlessCanceled(ctx context.Context, err error, args ...interface{}) bool {
+	return std.IfErrorUnlessCanceled(ctx, err, args...)
+}
+
+// IfWarn prints err to Output as a warning if the error is non-nil. Extra
+// arguments are converted to a string which, if present, annotates the error.
+// Returns true if the error is non-nil.
+func IfWarn(err error, args ...interface{}) bool {
+	return std.IfWarn(err, args...)
+}
+
+// IfWarnf prints err to Output as a warning if the err is non-nil. Extra
+// arguments are formatted as a string, according to the format argument. If
+// present, this string annotates the error. Returns true if the error is
+// non-nil.
+func IfWarnf(err error, format string, args ...interface{}) bool {
+	return std.IfWarnf(err, format, args...)
+}
+
+// errorCount and warnCount count the number of errors and warnings reported,
+// for Summary.
+var errorCount, warnCount atomic.Int64
+
+// Summary prints the number of errors and warnings reported by IfError,
+// IfWarn, and their variants, such as "3 errors, 1 warning". Nothing is printed
+// if no errors or warnings were reported. Summary is intended to be deferred at
+// the start of main:
+//
+//	defer but.Summary()
+func Summary() {
+	std.Summary()
+}
+
+// plural formats n followed by noun, which is pluralized if n is not 1.
+func plural(n int64, noun string) string {
+	if n == 1 {
+		return "1 " + noun
 	}
-	return false
+	return strconv.FormatInt(n, 10) + " " + noun + "s"
 }
 
-// IfFatal prints err to stderr and exits, if the error is non-nil. Extra
+// MustNot prints err to Output and panics, if the error is non-nil. Extra
 // arguments are converted to a string which, if present, annotates the error.
+// The annotated error is used as the panic value. Unlike IfFatal, the program
+// does not exit cleanly; the panic exposes the full stack, and allows deferred
+// functions to recover. This is intended for errors that indicate a bug.
+func MustNot(err error, args ...interface{}) {
+	std.MustNot(err, args...)
+}
+
+// Report prints err to Output if the error is non-nil, and returns code.
+// Extra arguments are converted to a string which, if present, annotates the
+// error. Returns 0 if the error is nil. Unlike IfFatal, Report does not exit,
+// leaving that to the caller:
+//
+//	os.Exit(but.Report(2, err, "usage"))
+func Report(code int, err error, args ...interface{}) int {
+	return std.Report(code, err, args...)
+}
+
+// IfFatal prints err as a fatal message and exits, if the error is non-nil.
+// Extra arguments are converted to a string which, if present, annotates the
+// error. If err, or any error it wraps, has an ExitCode method, the program
+// exits with the returned code instead of 1.
 func IfFatal(err error, args ...interface{}) {
-	if err != nil {
-		IfError(err, args...)
-		os.Exit(1)
-	}
+	std.IfFatal(err, args...)
 }
 
-// IfFatalf prints err to stderr and exits, if the err is non-nil. Extra
-// arguments are formatted as a string, according to the format argument. If
-// present, this string annotates the error.
+// IfFatalf prints err as a fatal message and exits, if the err is non-nil.
+// Extra arguments are formatted as a string, according to the format argument.
+// If present, this string annotates the error. The exit code is determined in
+// the same manner as IfFatal.
 func IfFatalf(err error, format string, args ...interface{}) {
-	if err != nil {
-		IfErrorf(err, format, args...)
-		os.Exit(1)
+	std.IfFatalf(err, format, args...)
+}
+
+// Must returns v if err is nil. Otherwise, err is printed as a fatal message,
+// and the program exits, in the same manner as IfFatal. This allows a call
+// that returns a value and an error to be checked in one line:
+//
+//	f := but.Must(os.Open(path))
+func Must[T any](v T, err error) T {
+	std.IfFatal(err)
+	return v
+}
+
+// Must2 returns a and b if err is nil. Otherwise, err is printed as a fatal
+// message, and the program exits, in the same manner as IfFatal.
+func Must2[A, B any](a A, b B, err error) (A, B) {
+	std.IfFatal(err)
+	return a, b
+}
+
+// MustAnnotate returns a function that returns v if err is nil. Otherwise, the
+// function prints err as a fatal message and exits, in the same manner as
+// IfFatal, where the arguments of the function annotate the error:
+//
+//	f := but.MustAnnotate(os.Open(path))("open config")
+func MustAnnotate[T any](v T, err error) func(args ...interface{}) T {
+	return func(args ...interface{}) T {
+		std.IfFatal(err, args...)
+		return v
+	}
+}
+
+// Mustf returns a function that returns v if err is nil. Otherwise, the
+// function prints err as a fatal message and exits, in the same manner as
+// IfFatalf, where the arguments of the function annotate the error:
+//
+//	f := but.Mustf(os.Open(path))("open %s", path)
+func Mustf[T any](v T, err error) func(format string, args ...interface{}) T {
+	return func(format string, args ...interface{}) T {
+		std.IfFatalf(err, format, args...)
+		return v
 	}
 }
 
-// Log prints the given arguments to stderr.
+// OK prints err in the same manner as IfError, if the error is non-nil.
+// Returns v, and whether err is nil. Unlike Must, the program does not exit,
+// which allows a loop to continue past a failed item:
+//
+//	for _, path := range paths {
+//		f, ok := but.OK(os.Open(path))
+//		if !ok {
+//			continue
+//		}
+//		...
+//	}
+func OK[T any](v T, err error) (T, bool) {
+	return v, !std.IfError(err)
+}
+
+// Log prints the given arguments to Output.
 func Log(args ...interface{}) {
-	fmt.Fprintln(os.Stderr, args...)
+	std.Log(args...)
 }
 
 // Logf formats the arguments according to format, and prints the result to
-// stderr.
+// Output.
 func Logf(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, format, args...)
+	std.Logf(format, args...)
+}
+
+// TableKeyWidth is the maximum width, in runes, of the key column printed by
+// LogTable. Keys wider than this are truncated with an ellipsis. Keys are not
+// truncated if TableKeyWidth is zero or less.
+var TableKeyWidth = 32
+
+// LogTable prints each row to Output as a line containing two columns: a key
+// and a message. The messages are aligned according to the widest key, up to
+// TableKeyWidth.
+func LogTable(rows [][2]string) {
+	std.LogTable(rows)
+}
+
+// Timed returns a function that prints the given arguments to Output, followed
+// by the time elapsed since Timed was called. It is intended to be deferred:
+//
+//	defer but.Timed("build")()
+//	// build took 1.2s
+//
+// The elapsed time is measured when the returned function is called.
+func Timed(args ...interface{}) func() {
+	return std.Timed(args...)
+}
+
+// DryRun indicates whether the program is performing a dry run, in which
+// actions are reported by Would rather than performed.
+var DryRun bool
+
+// Would prints the given arguments to Output, preceded by "[dry-run]", if
+// DryRun is true. Otherwise, nothing is printed.
+func Would(args ...interface{}) {
+	std.Would(args...)
+}
+
+// verbosity is the verbosity of the program.
+var verbosity atomic.Int32
+
+// SetVerbosity sets the verbosity of the program, which determines whether
+// messages printed by Debug, Trace, and Vlog are visible. It is intended to
+// correspond to the number of times a flag such as -v is repeated. Defaults to
+// 0.
+//
+// Verbosity also determines how much detail is printed by IfError and
+// IfErrorf. At 1 or higher, the location of the call is printed after the
+// error. At 2 or higher, an error that formats differently with %+v, such as
+// one carrying a stack trace, is printed in that expanded form.
+//
+// Verbosity is independent of Level. Verbosity only determines whether a
+// message is printed at all, while every message from Debug, Trace, and Vlog
+// is printed at LevelDebug.
+func SetVerbosity(level int) {
+	verbosity.Store(int32(level))
+}
+
+// Verbosity returns the verbosity of the program, as set by SetVerbosity.
+func Verbosity() int {
+	return int(verbosity.Load())
+}
+
+// V returns whether the verbosity is at least n.
+func V(n int) bool {
+	return int(verbosity.Load()) >= n
+}
+
+// Vlog prints the given arguments to Output at LevelDebug, if the verbosity is
+// at least n.
+func Vlog(n int, args ...interface{}) {
+	std.Vlog(n, args...)
+}
+
+// Debug prints the given arguments to Output at LevelDebug, if the verbosity is
+// at least 1.
+func Debug(args ...interface{}) {
+	std.Debug(args...)
+}
+
+// Debugf formats the arguments according to format, and prints the result to
+// Output at LevelDebug, if the verbosity is at least 1. The arguments are not
+// formatted otherwise.
+func Debugf(format string, args ...interface{}) {
+	std.Debugf(format, args...)
 }
 
-// Fatal prints the given arguments to stderr and exits.
+// Trace prints the given arguments to Output at LevelDebug, if the verbosity is
+// at least 2.
+func Trace(args ...interface{}) {
+	std.Trace(args...)
+}
+
+// Tracef formats the arguments according to format, and prints the result to
+// Output at LevelDebug, if the verbosity is at least 2. The arguments are not
+// formatted otherwise.
+func Tracef(format string, args ...interface{}) {
+	std.Tracef(format, args...)
+}
+
+// caller returns the file and line of the first caller outside of this
+// package, as "file:line". The package's own tests count as outside callers.
+// Returns an empty string if there is no such caller.
+func caller() string {
+	pc := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pc[:runtime.Callers(2, pc)])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/anaminus/but.") ||
+			strings.HasSuffix(frame.File, "_test.go") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+// Fatal prints the given arguments as a fatal message and exits.
 func Fatal(args ...interface{}) {
-	fmt.Fprintln(os.Stderr, args...)
-	os.Exit(1)
+	std.Fatal(args...)
+}
+
+// FFatal prints the given arguments as a fatal message to w, and exits. Unlike
+// Fatal, the message is not printed to Output or FatalOutput. Combined with
+// Exit, this allows a library to test its fatal paths in isolation.
+func FFatal(w io.Writer, args ...interface{}) {
+	(&But{Output: w}).Fatal(args...)
 }
 
-// Fatalf formats the arguments according to format, prints the result to
-// stderr, and exits.
+// Fatalf formats the arguments according to format, prints the result as a
+// fatal message, and exits.
 func Fatalf(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, format, args...)
-	os.Exit(1)
+	std.Fatalf(format, args...)
+}
+
+// Check is a condition checked by Validate.
+type Check struct {
+	// Cond is the condition, which fails if false.
+	Cond bool
+	// Msg describes the failure.
+	Msg string
+}
+
+// Validate prints each failed check as a fatal message and exits, if any
+// checks failed. All failures are reported together, rather than only the
+// first.
+func Validate(checks ...Check) {
+	std.Validate(checks...)
+}
+
+// ValidateErr returns an Errors containing the message of each failed check,
+// or nil if no checks failed.
+func ValidateErr(checks ...Check) error {
+	agg := Errors{Msg: "invalid arguments"}
+	for _, c := range checks {
+		if !c.Cond {
+			agg.Add(errors.New(c.Msg))
+		}
+	}
+	if len(agg.Errs) == 0 {
+		return nil
+	}
+	return agg
+}
+
+// Guard runs fn. If fn panics, the recovered value is printed as a fatal
+// message, and the program exits. A value that is an error, such as an Errors,
+// is displayed in the same way as a reported error. If FatalStack is true, the
+// stack of the panic is printed after the value. Guard is intended to wrap the
+// body of main or of a command handler:
+//
+//	but.Guard(func() {
+//		...
+//	})
+func Guard(fn func()) {
+	std.Guard(fn)
+}
+
+// panicString converts a recovered panic value to a string. An error, such as
+// an Errors, is displayed in the same way as a reported error. Other values are
+// formatted with fmt.Sprint.
+func panicString(r interface{}) string {
+	if err, ok := r.(error); ok {
+		return formatError(err)
+	}
+	return fmt.Sprint(r)
 }
 
 // Errors groups together multiple errors as a single error.
@@ -84,24 +1012,792 @@ type Errors struct {
 	Msg string
 	// Errs is the list of errors.
 	Errs []error
+	// Cap, if greater than zero, is the maximum number of errors stored by
+	// Add. Errors added beyond this are counted in Suppressed instead.
+	Cap int
+	// Suppressed is the number of errors that were not stored by Add due to
+	// Cap.
+	Suppressed int
+	// TrimMsg indicates whether Msg is removed from the start of each error
+	// that repeats it. For example, with a Msg of "validating", an error of
+	// "validating field x" is displayed as "field x".
+	TrimMsg bool
+	// ShowCodes indicates whether each error that is a CodedError is
+	// displayed with its code, as "[code] error".
+	ShowCodes bool
+	// MaxDepth, if greater than zero, is the number of levels of nested
+	// aggregates that are fully expanded by Error. Aggregates nested deeper
+	// than this are displayed compactly on a single line.
+	MaxDepth int
+	// Separator, if non-empty, is placed between errors instead of a newline
+	// and indentation, which displays the aggregate on a single line, such as
+	// "msg: a; b; c". Nested aggregates are displayed compactly, enclosed in
+	// parentheses.
+	Separator string
+	// Columns, if greater than 1, is the number of columns in which errors
+	// are laid out, which is more compact for long lists of short errors.
+	// Errors are laid out one per line instead if any error spans multiple
+	// lines, or if the columns would be wider than 80 characters.
+	Columns int
+}
+
+// Glyphs indicates whether each error within an Errors is displayed with a
+// preceding glyph, which makes aggregates easier to scan visually. ErrorGlyph
+// is used if the locale indicates UTF-8, and ErrorGlyphASCII is used
+// otherwise.
+var Glyphs bool
+
+// ErrorGlyph is the glyph displayed before errors when Glyphs is true.
+var ErrorGlyph = "✗"
+
+// ErrorGlyphASCII is displayed instead of ErrorGlyph when the locale does not
+// indicate UTF-8.
+var ErrorGlyphASCII = "x"
+
+// glyph returns the glyph to display before errors, according to the locale
+// set by the LC_ALL, LC_CTYPE, or LANG environment variables.
+func glyph() string {
+	for _, v := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale := os.Getenv(v); locale != "" {
+			locale = strings.ToUpper(locale)
+			if strings.Contains(locale, "UTF-8") || strings.Contains(locale, "UTF8") {
+				return ErrorGlyph
+			}
+			break
+		}
+	}
+	return ErrorGlyphASCII
+}
+
+// Add appends e to the list of errors, if e is non-nil. A nil *Errors is
+// treated as nil. If the list has already reached Cap, then Suppressed is
+// incremented instead.
+func (err *Errors) Add(e error) {
+	if skipped(e) {
+		return
+	}
+	if err.Cap > 0 && len(err.Errs) >= err.Cap {
+		err.Suppressed++
+		return
+	}
+	err.Errs = append(err.Errs, e)
 }
 
 // Error implements the error interface. Errors are displayed one per line,
-// each with indentation.
+// each with indentation, unless Separator is set. If any errors were
+// suppressed, their count is displayed on a final line.
 func (err Errors) Error() string {
-	s := make([]string, len(err.Errs)+1)
+	return err.join(formatError, 1, err.MaxDepth)
+}
+
+// Format implements fmt.Formatter. The %s and %v verbs print the same result
+// as Error, and %q prints it quoted. The %+v verb uses the same layout, but
+// prints each error with %+v, which expands errors that carry extra detail,
+// such as stack traces.
+func (err Errors) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			io.WriteString(s, err.join(func(e error) string {
+				return fmt.Sprintf("%+v", e)
+			}, 1, err.MaxDepth))
+			return
+		}
+		io.WriteString(s, err.Error())
+	case 's':
+		io.WriteString(s, err.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", err.Error())
+	default:
+		fmt.Fprintf(s, "%%!%c(but.Errors=%s)", verb, err.Error())
+	}
+}
+
+// join lays out the list of errors, where str converts each error to a
+// string. depth is the depth of the aggregate, starting at 1. If max is greater
+// than zero, nested aggregates are expanded up to a depth of max, and are
+// compacted beyond that. Nil errors are skipped.
+//
+// Each error is placed on its own line, indented under Msg. Without a Msg, the
+// first error takes the place of Msg, and the remaining errors are indented
+// under it. The continuing lines of an error, such as those of a nested
+// aggregate, are indented one level further than the aggregate containing it.
+// The errors of a nested aggregate without a Msg are placed at the same level
+// as the errors containing it.
+func (err Errors) join(str func(error) string, depth, max int) string {
+	s := err.entries(str, depth, max)
+	if err.Separator != "" {
+		if err.Msg == "" {
+			return strings.Join(s, err.Separator)
+		}
+		return err.Msg + ": " + strings.Join(s, err.Separator)
+	}
+	for i, es := range s {
+		s[i] = strings.ReplaceAll(es, "\n", "\n\t")
+	}
+	if err.Msg == "" {
+		return strings.Join(s, "\n\t")
+	}
+	if len(s) == 0 {
+		return err.Msg
+	}
+	return err.Msg + "\n\t" + strings.Join(s, "\n\t")
+}
+
+// entries returns each error of the aggregate as a string, in the manner of
+// join, but without indentation.
+func (err Errors) entries(str func(error) string, depth, max int) []string {
+	s := make([]string, 0, len(err.Errs)+1)
+	for _, e := range err.Errs {
+		if skipped(e) {
+			continue
+		}
+		var es string
+		sub, nested := aggregate(e)
+		switch {
+		case nested && err.Separator != "":
+			es = "(" + sub.compact() + ")"
+		case nested && (max <= 0 || depth < max) && sub.Msg == "" && sub.Separator == "":
+			s = append(s, sub.entries(str, depth+1, max)...)
+			continue
+		case nested && max > 0:
+			if depth < max {
+				es = sub.join(str, depth+1, max)
+			} else {
+				es = sub.compact()
+			}
+		default:
+			es = str(e)
+		}
+		if err.TrimMsg {
+			es = trimLeading(es, err.Msg)
+		}
+		if err.ShowCodes {
+			if c, ok := coded(e); ok {
+				es = "[" + c.Code + "] " + es
+			}
+		}
+		if Glyphs {
+			es = glyph() + " " + es
+		}
+		s = append(s, es)
+	}
+	if err.Separator == "" && err.Columns > 1 {
+		if rows, ok := columns(s, err.Columns); ok {
+			s = rows
+		}
+	}
+	if err.Suppressed > 0 {
+		s = append(s, fmt.Sprintf("(%d more suppressed)", err.Suppressed))
+	}
+	return s
+}
+
+// columnsWidth is the maximum width of a line of errors laid out in columns.
+const columnsWidth = 80
+
+// columns lays out entries in n aligned columns, with one string per row.
+// Returns false if any entry spans multiple lines, or if the rows would be
+// too wide.
+func columns(entries []string, n int) ([]string, bool) {
+	width := 0
+	for _, e := range entries {
+		if strings.Contains(e, "\n") {
+			return nil, false
+		}
+		if w := utf8.RuneCountInString(e); w > width {
+			width = w
+		}
+	}
+	const gap = 2
+	if n*width+(n-1)*gap > columnsWidth {
+		return nil, false
+	}
+	rows := make([]string, 0, (len(entries)+n-1)/n)
+	for i := 0; i < len(entries); i += n {
+		var row strings.Builder
+		for j := i; j < i+n && j < len(entries); j++ {
+			row.WriteString(entries[j])
+			if j+1 < i+n && j+1 < len(entries) {
+				pad := width - utf8.RuneCountInString(entries[j]) + gap
+				row.WriteString(strings.Repeat(" ", pad))
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	return rows, true
+}
+
+// compact lays out the list of errors on a single line, with nested aggregates
+// enclosed in parentheses:
+//
+//	msg: a; b; (sub: c; d)
+func (err Errors) compact() string {
+	s := make([]string, 0, len(err.Errs)+1)
+	for _, e := range err.Errs {
+		if skipped(e) {
+			continue
+		}
+		if sub, ok := aggregate(e); ok {
+			s = append(s, "("+sub.compact()+")")
+		} else {
+			s = append(s, formatError(e))
+		}
+	}
+	if err.Suppressed > 0 {
+		s = append(s, fmt.Sprintf("(%d more suppressed)", err.Suppressed))
+	}
+	if err.Msg == "" {
+		return strings.Join(s, "; ")
+	}
+	return err.Msg + ": " + strings.Join(s, "; ")
+}
+
+// Markdown lays out the aggregate as a Markdown list, with one item per error,
+// preceded by Msg in bold, if present:
+//
+//	**msg**
+//	- a
+//	- sub
+//	  - b
+//
+// A nested aggregate becomes an item containing a nested list. If it has no
+// Msg, its errors are instead included in the containing list.
+func (err Errors) Markdown() string {
+	var b strings.Builder
 	if err.Msg != "" {
-		s[0] = err.Msg
-	} else {
-		s[0] = "\n\t"
+		b.WriteString("**" + err.Msg + "**\n")
+	}
+	err.markdown(&b, "")
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// markdown writes each error as a list item to b, with each line preceded by
+// indent.
+func (err Errors) markdown(b *strings.Builder, indent string) {
+	for _, e := range err.Errs {
+		if skipped(e) {
+			continue
+		}
+		if sub, ok := aggregate(e); ok {
+			if sub.Msg == "" {
+				sub.markdown(b, indent)
+				continue
+			}
+			b.WriteString(indent + "- " + sub.Msg + "\n")
+			sub.markdown(b, indent+"  ")
+			continue
+		}
+		lines := strings.Split(formatError(e), "\n")
+		b.WriteString(indent + "- " + strings.Join(lines, "\n"+indent+"  ") + "\n")
 	}
-	for i, e := range err.Errs {
-		s[i+1] = e.Error()
+	if err.Suppressed > 0 {
+		fmt.Fprintf(b, "%s- (%d more suppressed)\n", indent, err.Suppressed)
 	}
-	return strings.Join(s, "\n\t")
 }
 
 // Errors returns the list of errors.
 func (err Errors) Errors() []error {
 	return err.Errs
 }
+
+// Unwrap returns the non-nil errors directly contained in the aggregate, which
+// allows errors.Is and errors.As to match any of them, including those within
+// nested aggregates. A nil *Errors is excluded.
+func (err Errors) Unwrap() []error {
+	errs := make([]error, 0, len(err.Errs))
+	for _, e := range err.Errs {
+		if !skipped(e) {
+			errs = append(errs, e)
+		}
+	}
+	return errs
+}
+
+// trimLeading removes msg from the start of s, along with any following colons
+// and spaces. msg is removed only if it is followed by a colon or space, so
+// that a partial word isn't removed. Trailing colons and spaces in msg are
+// ignored. s is returned unchanged if nothing would remain.
+func trimLeading(s, msg string) string {
+	msg = strings.TrimRight(msg, ": ")
+	if msg == "" || !strings.HasPrefix(s, msg) {
+		return s
+	}
+	rest := s[len(msg):]
+	if rest == "" || (rest[0] != ':' && rest[0] != ' ') {
+		return s
+	}
+	if rest = strings.TrimLeft(rest, ": "); rest == "" {
+		return s
+	}
+	return rest
+}
+
+// GroupBy partitions the list of errors according to the result of key. Each
+// group has the key as its Msg, and retains the relative order of its errors.
+// Nested aggregates are treated as single units, and are not flattened.
+func (err Errors) GroupBy(key func(error) string) map[string]Errors {
+	groups := map[string]Errors{}
+	for _, e := range err.Errs {
+		k := key(e)
+		g := groups[k]
+		g.Msg = k
+		g.Errs = append(g.Errs, e)
+		groups[k] = g
+	}
+	return groups
+}
+
+// DedupBy returns a copy of the aggregate where each error that is equivalent
+// to an earlier error, according to eq, is removed. For example, errors that
+// wrap the same sentinel can be collapsed with:
+//
+//	agg.DedupBy(func(a, b error) bool {
+//		return errors.Is(a, ErrSentinel) && errors.Is(b, ErrSentinel)
+//	})
+//
+// The order of the first occurrence of each error is preserved. The receiver is
+// not modified.
+func (err Errors) DedupBy(eq func(a, b error) bool) Errors {
+	errs := make([]error, 0, len(err.Errs))
+loop:
+	for _, e := range err.Errs {
+		for _, f := range errs {
+			if eq(f, e) {
+				continue loop
+			}
+		}
+		errs = append(errs, e)
+	}
+	err.Errs = errs
+	return err
+}
+
+// aggregate returns the Errors contained directly by e, if e is an Errors or
+// *Errors.
+func aggregate(e error) (Errors, bool) {
+	switch e := e.(type) {
+	case Errors:
+		return e, true
+	case *Errors:
+		if e != nil {
+			return *e, true
+		}
+	}
+	return Errors{}, false
+}
+
+// nilAggregate returns whether e is a nil *Errors, which contains no errors.
+func nilAggregate(e error) bool {
+	p, ok := e.(*Errors)
+	return ok && p == nil
+}
+
+// skipped returns whether e is skipped when displaying or traversing an
+// aggregate, which is the case for nil errors, including a nil *Errors.
+func skipped(e error) bool {
+	return e == nil || nilAggregate(e)
+}
+
+// All returns a sequence of each error in the aggregate, along with its index
+// within the sequence. Nested aggregates are recursively expanded into their
+// errors, rather than being yielded themselves. Nil errors are skipped.
+func (err Errors) All() iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		i := 0
+		var walk func(err Errors) bool
+		walk = func(err Errors) bool {
+			for _, e := range err.Errs {
+				if skipped(e) {
+					continue
+				}
+				if sub, ok := aggregate(e); ok {
+					if !walk(sub) {
+						return false
+					}
+					continue
+				}
+				if !yield(i, e) {
+					return false
+				}
+				i++
+			}
+			return true
+		}
+		walk(err)
+	}
+}
+
+// Direct returns a sequence of each error directly contained in the aggregate,
+// along with its index. Unlike All, nested aggregates are not expanded.
+func (err Errors) Direct() iter.Seq2[int, error] {
+	return func(yield func(int, error) bool) {
+		for i, e := range err.Errs {
+			if !yield(i, e) {
+				return
+			}
+		}
+	}
+}
+
+// Brief returns the message of the first error, followed by the number of other
+// errors, including those suppressed:
+//
+//	connection refused (and 4 more errors)
+//
+// Returns only the message if there is one error, or an empty string if there
+// are none. Nil errors are skipped.
+func (err Errors) Brief() string {
+	errs := err.Unwrap()
+	if len(errs) == 0 {
+		return ""
+	}
+	s := formatError(errs[0])
+	switch n := len(errs) - 1 + err.Suppressed; n {
+	case 0:
+	case 1:
+		s += " (and 1 more error)"
+	default:
+		s += " (and " + strconv.Itoa(n) + " more errors)"
+	}
+	return s
+}
+
+// Messages returns the message of each error in the aggregate, with nested
+// aggregates expanded into the messages of their errors. Nil errors are
+// skipped.
+func (err Errors) Messages() []string {
+	var msgs []string
+	for _, e := range err.All() {
+		if e != nil {
+			msgs = append(msgs, formatError(e))
+		}
+	}
+	return msgs
+}
+
+// Collapse returns an error from errs, excluding nil errors. Returns nil if
+// no errors remain, or the error itself if exactly one remains, which
+// preserves its type for errors.Is and errors.As. Otherwise, the errors are
+// returned as an Errors with msg as its Msg.
+func Collapse(msg string, errs ...error) error {
+	var agg Errors
+	for _, e := range errs {
+		agg.Add(e)
+	}
+	switch len(agg.Errs) {
+	case 0:
+		return nil
+	case 1:
+		return agg.Errs[0]
+	}
+	agg.Msg = msg
+	return agg
+}
+
+// Append returns an Errors containing the errors of err followed by errs. If
+// err is an Errors or *Errors, its fields are retained, and errs are added to a
+// copy of its list with Add. Otherwise, err becomes the first error of a new
+// Errors. Nil errors, including a nil *Errors, are dropped, and each aggregate
+// within errs is flattened into its errors. Returns nil if no errors remain,
+// so that the result can be checked directly:
+//
+//	var err error
+//	for _, f := range files {
+//		err = but.Append(err, process(f))
+//	}
+//	if err != nil {
+//		...
+//	}
+func Append(err error, errs ...error) error {
+	if nilAggregate(err) {
+		err = nil
+	}
+	agg, ok := aggregate(err)
+	if ok {
+		agg.Errs = append([]error(nil), agg.Unwrap()...)
+	} else {
+		agg.Add(err)
+	}
+	var flatten func(errs []error)
+	flatten = func(errs []error) {
+		for _, e := range errs {
+			if nilAggregate(e) {
+				continue
+			}
+			if sub, ok := aggregate(e); ok {
+				flatten(sub.Errs)
+				agg.Suppressed += sub.Suppressed
+				continue
+			}
+			agg.Add(e)
+		}
+	}
+	flatten(errs)
+	if len(agg.Errs) == 0 {
+		return nil
+	}
+	return agg
+}
+
+// FromMap returns an Errors with msg as its Msg, containing each non-nil error
+// in m annotated with its key, as "key: error". The errors are sorted by key,
+// so that the result is deterministic. Returns nil if m contains no non-nil
+// errors.
+func FromMap(msg string, m map[string]error) error {
+	keys := make([]string, 0, len(m))
+	for k, e := range m {
+		if e != nil {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	sort.Strings(keys)
+	agg := Errors{Msg: msg, Errs: make([]error, len(keys))}
+	for i, k := range keys {
+		agg.Errs[i] = fmt.Errorf("%s: %w", k, m[k])
+	}
+	return agg
+}
+
+// Each calls fn for each item, and returns an Errors with msg as its Msg,
+// containing each non-nil error returned by fn. Every item is processed,
+// regardless of failures. Each error is annotated with its item, as
+// "item: error", where the item is displayed with its String method if it
+// implements fmt.Stringer, or by its index otherwise. Returns nil if no errors
+// were returned.
+func Each[T any](items []T, fn func(T) error, msg string) error {
+	agg := Errors{Msg: msg}
+	for i, item := range items {
+		if err := fn(item); err != nil {
+			var key interface{} = i
+			if s, ok := interface{}(item).(fmt.Stringer); ok {
+				key = s.String()
+			}
+			agg.Add(fmt.Errorf("%v: %w", key, err))
+		}
+	}
+	if len(agg.Errs) == 0 {
+		return nil
+	}
+	return agg
+}
+
+// CollectUntil receives errors from ch until ch is closed or ctx is done,
+// returning an Errors containing each non-nil error, with msg as its Msg. If
+// ctx is done first, collecting stops, and the error of ctx is added as the
+// final entry. CollectUntil starts no goroutines, and never closes ch; the
+// sender remains responsible for closing it.
+func CollectUntil(ctx context.Context, ch <-chan error, msg string) Errors {
+	agg := Errors{Msg: msg}
+	for {
+		select {
+		case err, ok := <-ch:
+			if !ok {
+				return agg
+			}
+			agg.Add(err)
+		case <-ctx.Done():
+			agg.Add(ctx.Err())
+			return agg
+		}
+	}
+}
+
+// Collector gathers errors from multiple goroutines into an aggregate. Unlike
+// a group that stops on the first error, every error is collected:
+//
+//	c := but.NewCollector("processing inputs")
+//	for _, path := range paths {
+//		c.Go(func() error { return process(path) })
+//	}
+//	but.IfFatal(c.Wait())
+//
+// The methods of a Collector are safe to call from multiple goroutines.
+type Collector struct {
+	msg  string
+	mu   sync.Mutex
+	errs []error
+	wg   sync.WaitGroup
+}
+
+// NewCollector returns a Collector that produces an aggregate with msg as its
+// Msg.
+func NewCollector(msg string) *Collector {
+	return &Collector{msg: msg}
+}
+
+// Add adds err to the collected errors, if err is non-nil. Errors are kept in
+// the order in which they are added.
+func (c *Collector) Add(err error) {
+	if err == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.errs = append(c.errs, err)
+}
+
+// Go calls fn in a new goroutine, and adds the error it returns.
+func (c *Collector) Go(fn func() error) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		c.Add(fn())
+	}()
+}
+
+// Wait waits for each function started by Go to return, then returns the
+// result of Err.
+func (c *Collector) Wait() error {
+	c.wg.Wait()
+	return c.Err()
+}
+
+// Err returns the errors collected so far, in the same manner as Collapse.
+// Returns nil if no errors were added, the error itself if exactly one was
+// added, or an Errors with the Msg of the Collector otherwise.
+func (c *Collector) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch len(c.errs) {
+	case 0:
+		return nil
+	case 1:
+		return c.errs[0]
+	}
+	return Errors{Msg: c.msg, Errs: append([]error(nil), c.errs...)}
+}
+
+// Len returns the number of errors collected so far.
+func (c *Collector) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.errs)
+}
+
+// ErrorSink returns a writer that converts each line written to it into an
+// error, which allows a line-oriented stream, such as the stderr of a
+// subprocess, to be handled as an Errors. Empty lines are ignored, and a
+// partial line is held until it is completed by a newline, or until the writer
+// is closed.
+//
+// After the writer is closed, the returned function returns an Errors with msg
+// as its Msg, containing each line as an error, or nil if no lines were
+// written.
+func ErrorSink(msg string) (io.WriteCloser, func() error) {
+	s := &errorSink{errs: Errors{Msg: msg}}
+	return s, s.err
+}
+
+type errorSink struct {
+	mu     sync.Mutex
+	buf    []byte
+	errs   Errors
+	closed bool
+}
+
+func (s *errorSink) Write(p []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, os.ErrClosed
+	}
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			break
+		}
+		s.add(s.buf[:i])
+		s.buf = s.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (s *errorSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return os.ErrClosed
+	}
+	s.add(s.buf)
+	s.buf = nil
+	s.closed = true
+	return nil
+}
+
+// add adds line as an error, if it is not empty.
+func (s *errorSink) add(line []byte) {
+	if line := strings.TrimSuffix(string(line), "\r"); line != "" {
+		s.errs.Add(errors.New(line))
+	}
+}
+
+// err returns the collected errors.
+func (s *errorSink) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.errs.Errs) == 0 {
+		return nil
+	}
+	return s.errs
+}
+
+// CodedError associates an error with a code, which allows failures to be
+// categorized programmatically.
+type CodedError struct {
+	// Code is the code of the error.
+	Code string
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface, returning the message of the
+// underlying error.
+func (err CodedError) Error() string {
+	return err.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (err CodedError) Unwrap() error {
+	return err.Err
+}
+
+// coded returns the CodedError that e is or wraps. Only errors that wrap a
+// single error are followed, so that the code of an error within a nested
+// aggregate is not attributed to the aggregate itself.
+func coded(e error) (CodedError, bool) {
+	for ; e != nil; e = errors.Unwrap(e) {
+		if c, ok := e.(CodedError); ok {
+			return c, true
+		}
+	}
+	return CodedError{}, false
+}
+
+// AddCoded adds e to the list of errors as a CodedError with the given code,
+// in the same manner as Add.
+func (err *Errors) AddCoded(code string, e error) {
+	if e == nil {
+		return
+	}
+	err.Add(CodedError{Code: code, Err: e})
+}
+
+// Codes returns each distinct code of the CodedErrors within the aggregate, in
+// the order they first appear. Nested aggregates are included.
+func (err Errors) Codes() []string {
+	var codes []string
+	seen := map[string]bool{}
+	for _, e := range err.All() {
+		var c CodedError
+		if errors.As(e, &c) && !seen[c.Code] {
+			seen[c.Code] = true
+			codes = append(codes, c.Code)
+		}
+	}
+	return codes
+}
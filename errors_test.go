@@ -0,0 +1,448 @@
+package but
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+)
+
+// capture redirects Output to a buffer for the duration of the test, and
+// replaces Exit with a function that records its code in exit, which is -1
+// until Exit is called.
+func capture(t *testing.T) (out *bytes.Buffer, exit *int) {
+	t.Helper()
+	out = new(bytes.Buffer)
+	code := -1
+	prevOutput, prevExit := Output, Exit
+	SetOutput(out)
+	Exit = func(c int) { code = c }
+	t.Cleanup(func() {
+		SetOutput(prevOutput)
+		Exit = prevExit
+	})
+	return out, &code
+}
+
+// detailError is an error that prints extra detail with %+v.
+type detailError struct{ msg string }
+
+func (err detailError) Error() string { return err.msg }
+
+func (err detailError) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		fmt.Fprintf(s, "%s (detail)", err.msg)
+		return
+	}
+	fmt.Fprint(s, err.msg)
+}
+
+func TestErrorsFormat(t *testing.T) {
+	agg := Errors{Msg: "msg", Errs: []error{errors.New("a"), detailError{"b"}}}
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"%v", "msg\n\ta\n\tb"},
+		{"%+v", "msg\n\ta\n\tb (detail)"},
+		{"%s", agg.Error()},
+		{"%q", `"msg\n\ta\n\tb"`},
+		{"%d", "%!d(but.Errors=msg\n\ta\n\tb)"},
+	}
+	for _, test := range tests {
+		if got := fmt.Sprintf(test.format, agg); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.format, got, test.want)
+		}
+	}
+}
+
+func TestErrorsAll(t *testing.T) {
+	a, b, c, d := errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d")
+	agg := Errors{Errs: []error{a, nil, Errors{Errs: []error{b, nil, c}}, d}}
+
+	var got []error
+	for i, e := range agg.All() {
+		if i != len(got) {
+			t.Errorf("index %d, want %d", i, len(got))
+		}
+		got = append(got, e)
+	}
+	if want := []error{a, b, c, d}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	got = nil
+	for _, e := range agg.All() {
+		got = append(got, e)
+		if e == b {
+			break
+		}
+	}
+	if want := []error{a, b}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("break: got %v, want %v", got, want)
+	}
+}
+
+func TestErrorsDirect(t *testing.T) {
+	a, b := errors.New("a"), errors.New("b")
+	sub := Errors{Errs: []error{b}}
+	agg := Errors{Errs: []error{a, sub, b}}
+
+	n := 0
+	for i, e := range agg.Direct() {
+		if i != n {
+			t.Errorf("index %d, want %d", i, n)
+		}
+		if _, ok := e.(Errors); ok != (i == 1) {
+			t.Errorf("index %d: nested aggregate expanded", i)
+		}
+		n++
+		if i == 1 {
+			break
+		}
+	}
+	if n != 2 {
+		t.Errorf("break: got %d errors, want 2", n)
+	}
+}
+
+func TestTrimLeading(t *testing.T) {
+	tests := []struct {
+		s, msg string
+		want   string
+	}{
+		{"validating: field x", "validating", "field x"},
+		{"validating field x", "validating", "field x"},
+		{"validating: field x", "validating: ", "field x"},
+		{"validatingx field", "validating", "validatingx field"},
+		{"validating: ", "validating", "validating: "},
+		{"validating", "validating", "validating"},
+		{"field x", "validating", "field x"},
+		{"field x", "", "field x"},
+	}
+	for _, test := range tests {
+		if got := trimLeading(test.s, test.msg); got != test.want {
+			t.Errorf("trimLeading(%q, %q): got %q, want %q", test.s, test.msg, got, test.want)
+		}
+	}
+}
+
+func TestErrorsTrimMsg(t *testing.T) {
+	agg := Errors{
+		Msg:     "validating",
+		Errs:    []error{errors.New("validating field x"), errors.New("field y")},
+		TrimMsg: true,
+	}
+	if got, want := agg.Error(), "validating\n\tfield x\n\tfield y"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	agg.TrimMsg = false
+	if got, want := agg.Error(), "validating\n\tvalidating field x\n\tfield y"; got != want {
+		t.Errorf("without TrimMsg: got %q, want %q", got, want)
+	}
+}
+
+// withoutStack returns s without the trailing stack trace that follows errors
+// in debug builds.
+func withoutStack(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	for len(lines) > 0 && strings.HasPrefix(lines[len(lines)-1], "\tat ") {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "")
+}
+
+// firstLine returns s up to the first newline.
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func TestReport(t *testing.T) {
+	out, exit := capture(t)
+	if got := Report(2, nil, "usage"); got != 0 {
+		t.Errorf("nil error: got code %d, want 0", got)
+	}
+	if out.Len() != 0 {
+		t.Errorf("nil error: printed %q", out.String())
+	}
+	if got := Report(2, errors.New("bad flag"), "usage"); got != 2 {
+		t.Errorf("non-nil error: got code %d, want 2", got)
+	}
+	// Debug builds follow the message with a stack trace.
+	if got, want := firstLine(out.String()), "usage: bad flag"; got != want {
+		t.Errorf("non-nil error: printed %q, want %q", got, want)
+	}
+	if *exit != -1 {
+		t.Errorf("Report exited with %d", *exit)
+	}
+}
+
+func TestErrorsMaxDepth(t *testing.T) {
+	a, b, c, d := errors.New("a"), errors.New("b"), errors.New("c"), errors.New("d")
+	inner := Errors{Msg: "inner", Errs: []error{c, d}}
+	mid := Errors{Msg: "mid", Errs: []error{b, inner}}
+	top := Errors{Msg: "top", Errs: []error{a, mid}}
+	tests := []struct {
+		max  int
+		want string
+	}{
+		{0, "top\n\ta\n\tmid\n\t\tb\n\t\tinner\n\t\t\tc\n\t\t\td"},
+		{1, "top\n\ta\n\tmid: b; (inner: c; d)"},
+		{2, "top\n\ta\n\tmid\n\t\tb\n\t\tinner: c; d"},
+		{3, "top\n\ta\n\tmid\n\t\tb\n\t\tinner\n\t\t\tc\n\t\t\td"},
+	}
+	for _, test := range tests {
+		top.MaxDepth = test.max
+		if got := top.Error(); got != test.want {
+			t.Errorf("MaxDepth %d: got %q, want %q", test.max, got, test.want)
+		}
+	}
+}
+
+func TestErrorsSeparator(t *testing.T) {
+	a, b, c := errors.New("a"), errors.New("b"), errors.New("c")
+	sub := Errors{Msg: "s", Errs: []error{b, c}}
+	tests := []struct {
+		agg  Errors
+		want string
+	}{
+		{Errors{Msg: "m", Errs: []error{a, b}}, "m\n\ta\n\tb"},
+		{Errors{Errs: []error{a, b}}, "a\n\tb"},
+		{Errors{Msg: "m", Errs: []error{a, b}, Separator: "; "}, "m: a; b"},
+		{Errors{Errs: []error{a, b}, Separator: "; "}, "a; b"},
+		{Errors{Msg: "m", Errs: []error{a, sub}, Separator: "; "}, "m: a; (s: b; c)"},
+		{Errors{Errs: []error{sub}, Separator: ", "}, "(s: b; c)"},
+	}
+	for _, test := range tests {
+		if got := test.agg.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestErrorsBrief(t *testing.T) {
+	a, b := errors.New("a"), errors.New("b")
+	tests := []struct {
+		agg  Errors
+		want string
+	}{
+		{Errors{}, ""},
+		{Errors{Errs: []error{nil}}, ""},
+		{Errors{Errs: []error{a}}, "a"},
+		{Errors{Errs: []error{nil, a}}, "a"},
+		{Errors{Errs: []error{a, nil, b}}, "a (and 1 more error)"},
+		{Errors{Errs: []error{a, b}, Suppressed: 2}, "a (and 3 more errors)"},
+	}
+	for _, test := range tests {
+		if got := test.agg.Brief(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+// kindError is an error type matched with errors.As.
+type kindError struct{ kind string }
+
+func (err kindError) Error() string { return "kind " + err.kind }
+
+func TestErrorsUnwrap(t *testing.T) {
+	inner := Errors{Msg: "inner", Errs: []error{
+		nil,
+		fmt.Errorf("open: %w", os.ErrNotExist),
+		kindError{"deep"},
+	}}
+	mid := Errors{Msg: "mid", Errs: []error{errors.New("a"), &inner, (*Errors)(nil)}}
+	top := Errors{Msg: "top", Errs: []error{errors.New("b"), mid}}
+
+	if !errors.Is(top, fs.ErrNotExist) {
+		t.Error("errors.Is: sentinel two levels deep not found")
+	}
+	if errors.Is(top, fs.ErrPermission) {
+		t.Error("errors.Is: unexpected match")
+	}
+	var k kindError
+	if !errors.As(top, &k) || k.kind != "deep" {
+		t.Errorf("errors.As: got %v", k)
+	}
+	if got := len(inner.Unwrap()); got != 2 {
+		t.Errorf("Unwrap: got %d errors, want 2", got)
+	}
+	if got := len(mid.Unwrap()); got != 2 {
+		t.Errorf("Unwrap: got %d errors, want 2, excluding the nil *Errors", got)
+	}
+	if got, want := len(top.Messages()), 4; got != want {
+		t.Errorf("Messages: got %d, want %d", got, want)
+	}
+}
+
+func TestAppend(t *testing.T) {
+	if err := Append(nil); err != nil {
+		t.Errorf("no errors: got %v", err)
+	}
+	if err := Append(nil, nil, nil); err != nil {
+		t.Errorf("all nil: got %v", err)
+	}
+	if err := Append(Errors{Msg: "m"}, nil, Errors{}); err != nil {
+		t.Errorf("empty aggregates: got %v", err)
+	}
+	if err := Append((*Errors)(nil), nil, (*Errors)(nil)); err != nil {
+		t.Errorf("nil *Errors: got %v", err)
+	}
+
+	x := errors.New("x")
+	err := Append((*Errors)(nil), x)
+	if got, want := fmt.Sprint(err), "x"; got != want {
+		t.Errorf("nil *Errors then x: got %q, want %q", got, want)
+	}
+
+	a, b, c := errors.New("a"), errors.New("b"), errors.New("c")
+	base := Errors{Msg: "m", Errs: []error{a}}
+	err = Append(base, nil, Errors{Errs: []error{b, Errors{Errs: []error{nil, c}}}})
+	agg, ok := err.(Errors)
+	if !ok {
+		t.Fatalf("got %T, want Errors", err)
+	}
+	if agg.Msg != "m" || fmt.Sprint(agg.Errs) != fmt.Sprint([]error{a, b, c}) {
+		t.Errorf("got %q %v", agg.Msg, agg.Errs)
+	}
+	if len(base.Errs) != 1 {
+		t.Errorf("base modified: %v", base.Errs)
+	}
+}
+
+func TestErrorsNested(t *testing.T) {
+	a, b, c := errors.New("a"), errors.New("b"), errors.New("c")
+	inner := Errors{Msg: "inner", Errs: []error{b, c}}
+	tests := []struct {
+		agg  Errors
+		want string
+	}{
+		{Errors{Errs: []error{a, nil, b}}, "a\n\tb"},
+		{Errors{Msg: "m", Errs: []error{a, (*Errors)(nil), b}}, "m\n\ta\n\tb"},
+		{Errors{Msg: "m"}, "m"},
+		{Errors{Msg: "m", Errs: []error{nil, (*Errors)(nil)}}, "m"},
+		{Errors{}, ""},
+		{Errors{Msg: "outer", Errs: []error{a, Errors{Errs: []error{b, c}}}}, "outer\n\ta\n\tb\n\tc"},
+		{Errors{Msg: "outer", Errs: []error{a, inner}}, "outer\n\ta\n\tinner\n\t\tb\n\t\tc"},
+		{Errors{Msg: "outer", Errs: []error{a, fmt.Errorf("w: %w", inner)}}, "outer\n\ta\n\tw: inner\n\t\tb\n\t\tc"},
+		{Errors{Errs: []error{a, inner}}, "a\n\tinner\n\t\tb\n\t\tc"},
+		{Errors{Errs: []error{inner, a}}, "inner\n\t\tb\n\t\tc\n\ta"},
+		{
+			Errors{Msg: "top", ShowCodes: true, Errs: []error{
+				Errors{Msg: "sub", Errs: []error{CodedError{"E1", a}, b}},
+				CodedError{"E2", c},
+			}},
+			"top\n\tsub\n\t\ta\n\t\tb\n\t[E2] c",
+		},
+	}
+	for _, test := range tests {
+		if got := test.agg.Error(); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestSanitizeControl(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"\x1b[31mred", `\x1b[31mred`},
+		{"over\rwrite", `over\x0dwrite`},
+		{"a\n\tb", "a\n\tb"},
+		{"next\u0085line", `next\x85line`},
+		{"bad \xff\xfe utf8", "bad \xff\xfe utf8"},
+		{"plain ✓", "plain ✓"},
+	}
+	for _, test := range tests {
+		if got := string(appendSanitized(nil, test.s)); got != test.want {
+			t.Errorf("appendSanitized(%q): got %q, want %q", test.s, got, test.want)
+		}
+	}
+
+	out, _ := capture(t)
+	Log("\x1b[2Jcleared")
+	if got, want := out.String(), "\\x1b[2Jcleared\n"; got != want {
+		t.Errorf("Log: got %q, want %q", got, want)
+	}
+}
+
+// recordSink records each message, and forwards it to DefaultSink.
+type recordSink struct{ msgs []string }
+
+func (r *recordSink) Write(level Level, msg string) {
+	r.msgs = append(r.msgs, level.String()+" "+msg)
+	DefaultSink().Write(level, msg)
+}
+
+func TestDefaultSink(t *testing.T) {
+	out, _ := capture(t)
+	r := &recordSink{}
+	SetSink(r)
+	t.Cleanup(func() { SetSink(nil) })
+
+	Log("hello")
+	IfError(errors.New("boom"), "ctx")
+	// Debug builds follow the error with a stack trace.
+	if len(r.msgs) != 2 || r.msgs[0] != "info hello\n" || !strings.HasPrefix(r.msgs[1], "error ctx: boom\n") {
+		t.Errorf("recorded %q", r.msgs)
+	}
+	if got := out.String(); !strings.HasPrefix(got, "hello\nctx: boom\n") {
+		t.Errorf("forwarded %q", got)
+	}
+
+	SetSink(nil)
+	out.Reset()
+	Log("restored")
+	if got, want := out.String(), "restored\n"; got != want {
+		t.Errorf("after SetSink(nil): got %q, want %q", got, want)
+	}
+	if len(r.msgs) != 2 {
+		t.Errorf("sink called after SetSink(nil)")
+	}
+}
+
+func TestIfErrorAggregate(t *testing.T) {
+	out, _ := capture(t)
+	prevPrefix := prefix
+	t.Cleanup(func() {
+		SetStyle(0)
+		SetPrefix(prevPrefix)
+		SetFormat(FormatText)
+	})
+	a, b := errors.New("a"), errors.New("b")
+	tests := []struct {
+		style Style
+		err   error
+		args  []interface{}
+		want  string
+	}{
+		{0, Errors{Msg: "m"}, nil, "m"},
+		{0, Errors{Errs: []error{a, b}}, []interface{}{"ctx"}, "ctx: a\n\tb"},
+		{0, Errors{Msg: "m", Errs: []error{a, (*Errors)(nil)}}, nil, "m\n\ta"},
+		{Prefixed, Errors{Errs: []error{a, b}}, []interface{}{"ctx"}, "tool: error: ctx: a\n\tb"},
+	}
+	SetPrefix("tool")
+	for _, test := range tests {
+		out.Reset()
+		SetStyle(test.style)
+		IfError(test.err, test.args...)
+		if got := withoutStack(out.String()); got != test.want+"\n" {
+			t.Errorf("got %q, want %q", got, test.want+"\n")
+		}
+	}
+
+	out.Reset()
+	SetStyle(0)
+	SetFormat(FormatJSON)
+	IfError(Errors{Msg: "m", Errs: []error{a, (*Errors)(nil)}})
+	if !strings.Contains(out.String(), `"errors":[{"message":"a"`) {
+		t.Errorf("FormatJSON: got %q", out.String())
+	}
+}
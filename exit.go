@@ -0,0 +1,109 @@
+package but
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// Exit is called by fatal functions to terminate the program with the given
+// status code. It may be replaced, for example, to intercept fatal paths in
+// tests. Defaults to a function that runs the functions registered with
+// AtExit, then calls os.Exit. Fatal functions run the functions registered
+// with AtExit before calling OnFatal and Exit, so they run even if Exit is
+// replaced.
+//
+// Exit may also be called directly, so that a successful shutdown runs the
+// same functions as a fatal one:
+//
+//	but.Exit(0)
+var Exit = exit
+
+var (
+	atExitMu      sync.Mutex
+	atExitFuncs   []func()
+	atExitRunning bool
+)
+
+// AtExit registers fn to be called before the program terminates, either by a
+// fatal function or by Exit. Functions are called in the reverse order in which
+// they were registered, similar to deferred functions. AtExit is safe to call
+// from multiple goroutines.
+//
+// Each function is run only once. If a function calls Exit, such as through a
+// fatal function, the program terminates immediately, without running the
+// remaining functions.
+func AtExit(fn func()) {
+	atExitMu.Lock()
+	defer atExitMu.Unlock()
+	atExitFuncs = append(atExitFuncs, fn)
+}
+
+// exit runs the functions registered with AtExit, then terminates the program
+// with code.
+func exit(code int) {
+	runAtExit()
+	os.Exit(code)
+}
+
+// runAtExit calls and removes each function registered with AtExit, starting
+// with the most recent. Does nothing if called by one of the functions, or
+// while they are running.
+func runAtExit() {
+	atExitMu.Lock()
+	if atExitRunning {
+		atExitMu.Unlock()
+		return
+	}
+	atExitRunning = true
+	for len(atExitFuncs) > 0 {
+		fn := atExitFuncs[len(atExitFuncs)-1]
+		atExitFuncs = atExitFuncs[:len(atExitFuncs)-1]
+		atExitMu.Unlock()
+		fn()
+		atExitMu.Lock()
+	}
+	atExitRunning = false
+	atExitMu.Unlock()
+}
+
+// WithExitCode returns err with an ExitCode method that returns code, which
+// causes IfFatal and IfFatalf to exit with code instead of 1. code must be
+// greater than zero, since a fatal error cannot exit successfully; otherwise,
+// the program exits with 1 as usual. Returns nil if err is nil.
+func WithExitCode(err error, code int) error {
+	if err == nil {
+		return nil
+	}
+	return exitCodeError{err: err, code: code}
+}
+
+type exitCodeError struct {
+	err  error
+	code int
+}
+
+func (err exitCodeError) Error() string {
+	return err.err.Error()
+}
+
+func (err exitCodeError) Unwrap() error {
+	return err.err
+}
+
+func (err exitCodeError) ExitCode() int {
+	return err.code
+}
+
+// exitCode returns the status code with which a fatal err exits. If err, or
+// any error it wraps, has an ExitCode method that returns a code greater than
+// zero, then that code is returned. Otherwise, 1 is returned.
+func exitCode(err error) int {
+	var c interface{ ExitCode() int }
+	if errors.As(err, &c) {
+		if code := c.ExitCode(); code > 0 {
+			return code
+		}
+	}
+	return 1
+}
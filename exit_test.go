@@ -0,0 +1,25 @@
+package but
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestFatalOrder(t *testing.T) {
+	_, exit := capture(t)
+	var order []string
+	AtExit(func() { order = append(order, "hook 1:"+LastFatal) })
+	AtExit(func() { order = append(order, "hook 2") })
+	prevOnFatal := OnFatal
+	OnFatal = func(msg string) { order = append(order, "OnFatal") }
+	t.Cleanup(func() { OnFatal = prevOnFatal })
+
+	IfFatal(WithExitCode(errors.New("usage"), 2))
+	if want := "[hook 2 hook 1:usage OnFatal]"; fmt.Sprint(order) != want {
+		t.Errorf("got %v, want %s", order, want)
+	}
+	if *exit != 2 {
+		t.Errorf("exit code: got %d, want 2", *exit)
+	}
+}
@@ -0,0 +1,258 @@
+package but
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// OutputFormat is the format in which messages are printed.
+type OutputFormat int
+
+const (
+	// FormatText prints messages as plain text.
+	FormatText OutputFormat = iota
+	// FormatProblem prints each message as a JSON object on its own line,
+	// modeled after the problem details of RFC 7807. Such an object has the
+	// following members:
+	//
+	//	type:     Always "about:blank".
+	//	title:    The outermost message of the error, such as its annotation.
+	//	detail:   The full message of the error.
+	//	severity: The name of the Level of the message.
+	//
+	// An error that is or wraps an Errors is printed as an array containing
+	// an object for each error within the aggregate. A message that is not an
+	// error, such as one printed by Log, is printed as an object with only a
+	// title.
+	//
+	// Prefixes, IncludePID, IncludeCWD, SanitizeControl, and MaxLineWidth
+	// apply only to FormatText.
+	FormatProblem
+	// FormatJSON prints each message as a JSON object on its own line, which
+	// has the following members:
+	//
+	//	time:     The time the message was printed, in RFC 3339 format.
+	//	severity: "log" for messages printed by Log and Logf, or the name of
+	//	          the Level of the message otherwise, such as "error" or
+	//	          "fatal".
+	//	message:  The message, or the outermost message of an error, such as
+	//	          its annotation.
+	//	error:    The full message of the error. Omitted if the message is
+	//	          not an error.
+	//	chain:    The messages of the error and each error it wraps, from
+	//	          outermost to innermost.
+	//	errors:   If the error is or wraps an Errors, an array containing an
+	//	          object for each error within the aggregate, with the
+	//	          message, error, chain, and errors members.
+	//
+	// As with FormatProblem, the options that modify the text of messages
+	// apply only to FormatText.
+	FormatJSON
+)
+
+// Format is the format in which messages are printed. Defaults to FormatText.
+var Format = FormatText
+
+// SetFormat sets Format to f. It may be used to change the format while
+// messages may be printed concurrently.
+func SetFormat(f OutputFormat) {
+	mu.Lock()
+	defer mu.Unlock()
+	Format = f
+}
+
+// Entry is a message to be encoded by an Encoder.
+type Entry struct {
+	// Time is the time at which the message was printed.
+	Time time.Time
+	// Level is the level of the message.
+	Level Level
+	// Message is the message without a trailing newline. For an error, this
+	// is the outermost message of the error, such as its annotation.
+	Message string
+	// Err is the printed error, or nil if the message is not an error.
+	Err error
+	// Text is the message as it would be printed in FormatText.
+	Text string
+}
+
+// Encoder encodes messages in a custom format.
+type Encoder interface {
+	// Encode returns e as it will be printed, which should end with a
+	// newline.
+	Encode(e Entry) string
+}
+
+// encoder is the Encoder set by SetEncoder.
+var encoder Encoder
+
+// SetEncoder sets the encoder used to encode each message. If enc is non-nil,
+// Format is ignored, and enc is used instead. As with FormatJSON, the options
+// that modify the text of messages do not apply.
+func SetEncoder(enc Encoder) {
+	mu.Lock()
+	defer mu.Unlock()
+	encoder = enc
+}
+
+// encoding returns whether messages are encoded rather than printed as text.
+// Must be called while mu is held.
+func encoding() bool {
+	return encoder != nil || Format != FormatText
+}
+
+// currentEncoder returns the Encoder for the current Format, or nil for
+// FormatText.
+func currentEncoder() Encoder {
+	mu.Lock()
+	defer mu.Unlock()
+	if encoder != nil {
+		return encoder
+	}
+	switch Format {
+	case FormatProblem:
+		return problemEncoder{}
+	case FormatJSON:
+		return jsonEncoder{}
+	}
+	return nil
+}
+
+// encode returns the message s of the given level, encoded according to
+// Format.
+func encode(level Level, s string) string {
+	enc := currentEncoder()
+	if enc == nil {
+		return s
+	}
+	return enc.Encode(Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: strings.TrimSuffix(s, "\n"),
+		Text:    s,
+	})
+}
+
+// encodeError returns err as a message of the given level, encoded according to
+// Format. text is the message in FormatText.
+func encodeError(level Level, err error, text string) string {
+	enc := currentEncoder()
+	if enc == nil {
+		return text
+	}
+	return enc.Encode(Entry{
+		Time:    time.Now(),
+		Level:   level,
+		Message: outerMessage(err),
+		Err:     err,
+		Text:    text,
+	})
+}
+
+// problemEncoder encodes messages in FormatProblem.
+type problemEncoder struct{}
+
+// problem is a problem details object printed in FormatProblem.
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail,omitempty"`
+	Severity string `json:"severity"`
+}
+
+func (problemEncoder) Encode(e Entry) string {
+	if e.Err == nil {
+		return marshal(problem{
+			Type:     "about:blank",
+			Title:    e.Message,
+			Severity: e.Level.String(),
+		})
+	}
+	var agg Errors
+	if !errors.As(e.Err, &agg) {
+		return marshal(errorProblem(e.Level, e.Err))
+	}
+	problems := []problem{}
+	for _, err := range agg.All() {
+		if err != nil {
+			problems = append(problems, errorProblem(e.Level, err))
+		}
+	}
+	return marshal(problems)
+}
+
+// errorProblem returns err as a problem of the given level.
+func errorProblem(level Level, err error) problem {
+	return problem{
+		Type:     "about:blank",
+		Title:    outerMessage(err),
+		Detail:   formatError(err),
+		Severity: level.String(),
+	}
+}
+
+// jsonEncoder encodes messages in FormatJSON.
+type jsonEncoder struct{}
+
+// jsonError is an error printed in FormatJSON.
+type jsonError struct {
+	Message string      `json:"message"`
+	Error   string      `json:"error,omitempty"`
+	Chain   []string    `json:"chain,omitempty"`
+	Errors  []jsonError `json:"errors,omitempty"`
+}
+
+// jsonEntry is a message printed in FormatJSON.
+type jsonEntry struct {
+	Time     string `json:"time"`
+	Severity string `json:"severity"`
+	jsonError
+}
+
+func (jsonEncoder) Encode(e Entry) string {
+	entry := jsonEntry{
+		Time:      e.Time.Format(time.RFC3339),
+		Severity:  e.Level.String(),
+		jsonError: jsonError{Message: e.Message},
+	}
+	if e.Level == LevelInfo {
+		entry.Severity = "log"
+	}
+	if e.Err != nil {
+		entry.jsonError = newJSONError(e.Err)
+	}
+	return marshal(entry)
+}
+
+// newJSONError returns err as a jsonError. The chain follows err through each
+// error it wraps, and stops at an aggregate, whose errors become nested.
+func newJSONError(err error) jsonError {
+	j := jsonError{Message: outerMessage(err), Error: formatError(err)}
+	if agg, ok := aggregate(err); ok {
+		j.Message = agg.Msg
+	}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		j.Chain = append(j.Chain, formatError(e))
+		if agg, ok := aggregate(e); ok {
+			for _, sub := range agg.Errs {
+				if !skipped(sub) {
+					j.Errors = append(j.Errors, newJSONError(sub))
+				}
+			}
+			break
+		}
+	}
+	return j
+}
+
+// marshal encodes v as a line of JSON.
+func marshal(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("{\"type\":\"about:blank\",\"title\":%q}\n", err.Error())
+	}
+	return string(b) + "\n"
+}
@@ -0,0 +1,112 @@
+package but
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeJSON decodes each line of s as a jsonEntry.
+func decodeJSON(t *testing.T, s string) []jsonEntry {
+	t.Helper()
+	var entries []jsonEntry
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		var e jsonEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("decoding %q: %v", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestFormatJSON(t *testing.T) {
+	out, exit := capture(t)
+	SetFormat(FormatJSON)
+	t.Cleanup(func() { SetFormat(FormatText) })
+
+	base := errors.New("base")
+	wrapped := fmt.Errorf("wrapped: %w", base)
+	agg := Errors{Msg: "m", Errs: []error{errors.New("a"), wrapped}}
+	tests := []struct {
+		name string
+		call func()
+		want jsonEntry
+	}{
+		{"IfError", func() { IfError(wrapped, "ctx") }, jsonEntry{
+			Severity: "error",
+			jsonError: jsonError{
+				Message: "ctx",
+				Error:   "ctx: wrapped: base",
+				Chain:   []string{"ctx: wrapped: base", "wrapped: base", "base"},
+			},
+		}},
+		{"IfError aggregate", func() { IfError(agg) }, jsonEntry{
+			Severity: "error",
+			jsonError: jsonError{
+				Message: "m",
+				Error:   "m\n\ta\n\twrapped: base",
+				Chain:   []string{"m\n\ta\n\twrapped: base"},
+				Errors: []jsonError{
+					{Message: "a", Error: "a", Chain: []string{"a"}},
+					{Message: "wrapped", Error: "wrapped: base", Chain: []string{"wrapped: base", "base"}},
+				},
+			},
+		}},
+		{"Log", func() { Log("hello") }, jsonEntry{
+			Severity:  "log",
+			jsonError: jsonError{Message: "hello"},
+		}},
+		{"Fatal", func() { Fatal("stop") }, jsonEntry{
+			Severity:  "fatal",
+			jsonError: jsonError{Message: "stop"},
+		}},
+	}
+	for _, test := range tests {
+		out.Reset()
+		test.call()
+		entries := decodeJSON(t, out.String())
+		if len(entries) != 1 {
+			t.Errorf("%s: got %d entries, want 1", test.name, len(entries))
+			continue
+		}
+		got := entries[0]
+		if _, err := time.Parse(time.RFC3339, got.Time); err != nil {
+			t.Errorf("%s: time %q is not RFC 3339: %v", test.name, got.Time, err)
+		}
+		got.Time = ""
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s:\ngot  %+v\nwant %+v", test.name, got, test.want)
+		}
+	}
+	if *exit != 1 {
+		t.Errorf("exit code: got %d, want 1", *exit)
+	}
+}
+
+func TestFormatText(t *testing.T) {
+	out, _ := capture(t)
+	wrapped := fmt.Errorf("wrapped: %w", errors.New("base"))
+	tests := []struct {
+		call func()
+		want string
+	}{
+		{func() { IfError(wrapped, "ctx") }, "ctx: wrapped: base\n"},
+		{func() { IfErrorf(wrapped, "ctx %d", 2) }, "ctx 2: wrapped: base\n"},
+		{func() { IfError(Errors{Msg: "m", Errs: []error{errors.New("a"), wrapped}}) }, "m\n\ta\n\twrapped: base\n"},
+		{func() { Log("hello") }, "hello\n"},
+		{func() { Logf("n=%d\n", 3) }, "n=3\n"},
+		{func() { Fatal("stop") }, "stop\n"},
+	}
+	for _, test := range tests {
+		out.Reset()
+		test.call()
+		if got := withoutStack(out.String()); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package but
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMustPassThrough(t *testing.T) {
+	out, exit := capture(t)
+	p := &struct{}{}
+	if got := Must(p, nil); got != p {
+		t.Errorf("Must: got %p, want %p", got, p)
+	}
+	if a, b := Must2(42, "x", nil); a != 42 || b != "x" {
+		t.Errorf("Must2: got %v, %v", a, b)
+	}
+	if got := MustAnnotate(3, nil)("ctx"); got != 3 {
+		t.Errorf("MustAnnotate: got %v", got)
+	}
+	if got := Mustf("v", nil)("ctx %d", 1); got != "v" {
+		t.Errorf("Mustf: got %v", got)
+	}
+	if got, ok := OK(7, nil); got != 7 || !ok {
+		t.Errorf("OK: got %v, %v", got, ok)
+	}
+	if out.Len() != 0 || *exit != -1 {
+		t.Errorf("printed %q, exit %d", out.String(), *exit)
+	}
+}
+
+func TestMustFatal(t *testing.T) {
+	out, exit := capture(t)
+	err := WithExitCode(errors.New("boom"), 3)
+
+	// printed returns what fn prints and the code it exits with.
+	printed := func(fn func()) (string, int) {
+		out.Reset()
+		*exit = -1
+		fn()
+		return out.String(), *exit
+	}
+	tests := []struct {
+		name string
+		fn   func()
+		want func()
+	}{
+		{"Must", func() { Must(0, err) }, func() { IfFatal(err) }},
+		{"Must2", func() { Must2(0, 0, err) }, func() { IfFatal(err) }},
+		{"MustAnnotate", func() { MustAnnotate(0, err)("ctx") }, func() { IfFatal(err, "ctx") }},
+		{"Mustf", func() { Mustf(0, err)("ctx %d", 1) }, func() { IfFatalf(err, "ctx %d", 1) }},
+	}
+	for _, test := range tests {
+		want, _ := printed(test.want)
+		got, code := printed(test.fn)
+		if got != want {
+			t.Errorf("%s: printed %q, want %q", test.name, got, want)
+		}
+		if code != 3 {
+			t.Errorf("%s: exit code %d, want 3", test.name, code)
+		}
+	}
+}
+
+func TestOKError(t *testing.T) {
+	out, exit := capture(t)
+	err := errors.New("boom")
+	IfError(err)
+	want := out.String()
+	out.Reset()
+	if got, ok := OK(5, err); got != 5 || ok {
+		t.Errorf("got %v, %v, want 5, false", got, ok)
+	}
+	if got := out.String(); got != want {
+		t.Errorf("printed %q, want %q", got, want)
+	}
+	if *exit != -1 {
+		t.Errorf("OK exited with %d", *exit)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !debug
+
+package but
+
+// debugStack returns an empty string in release builds. See debug.go.
+func debugStack() string {
+	return ""
+}
@@ -0,0 +1,46 @@
+package but
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// OnSignal installs a handler for the given signals, defaulting to
+// os.Interrupt and SIGTERM if none are given. Returns a channel that is closed
+// when the first signal is received, so that the program may begin shutting
+// down, and a function that removes the handler.
+//
+// On the first signal, a message is printed to Output. On a second signal, a
+// fatal message is printed, and the program exits immediately.
+func OnSignal(sig ...os.Signal) (<-chan struct{}, func()) {
+	if len(sig) == 0 {
+		sig = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 2)
+	signal.Notify(c, sig...)
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case s := <-c:
+			std.writef(LevelInfo, "received signal %v, shutting down\n", s)
+			close(done)
+		case <-stop:
+			return
+		}
+		select {
+		case s := <-c:
+			std.die(std.writef(LevelFatal, "received signal %v again, exiting\n", s), 1)
+		case <-stop:
+		}
+	}()
+	var once sync.Once
+	return done, func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(stop)
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package but
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Style is a set of flags that determine how messages are decorated in
+// FormatText.
+type Style int
+
+const (
+	// Prefixed precedes each message with the program name, as set by
+	// SetPrefix, and precedes errors and fatal messages with a severity tag:
+	//
+	//	mytool: error: open config: no such file or directory
+	//
+	// Only the first line of a multi-line message is prefixed.
+	Prefixed Style = 1 << iota
+	// Color colors the severity tag added by Prefixed, when written to a
+	// terminal. Color is never used if the NO_COLOR environment variable is
+	// set to a non-empty value, or if disabled with SetColor.
+	Color
+)
+
+var (
+	// style is the current Style. Guarded by mu.
+	style Style
+	// prefix is the program name set by SetPrefix. Guarded by mu.
+	prefix = programName()
+	// noColor indicates whether color is disabled by SetColor. Guarded by mu.
+	noColor bool
+)
+
+// programName returns the base name of os.Args[0], or an empty string if
+// os.Args is empty.
+func programName() string {
+	if len(os.Args) > 0 {
+		return filepath.Base(os.Args[0])
+	}
+	return ""
+}
+
+// SetStyle sets the style in which messages are printed. The default of zero
+// prints messages without decoration:
+//
+//	but.SetStyle(but.Prefixed | but.Color)
+func SetStyle(s Style) {
+	mu.Lock()
+	defer mu.Unlock()
+	style = s
+}
+
+// SetPrefix sets the program name that precedes each message when the style
+// includes Prefixed. Defaults to the base name of os.Args[0]. If name is empty,
+// only the severity tag is printed.
+func SetPrefix(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	prefix = name
+}
+
+// SetColor sets whether the style may use Color. Defaults to true.
+func SetColor(on bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	noColor = !on
+}
+
+// levelTag returns the severity tag of the given level, or an empty string if
+// the level has no tag.
+func levelTag(level Level) string {
+	switch level {
+	case LevelError:
+		return "error:"
+	case LevelFatal:
+		return "fatal:"
+	}
+	return ""
+}
+
+// levelColor returns the escape sequence that colors the tag of the given
+// level.
+func levelColor(level Level) string {
+	if level == LevelFatal {
+		return "\x1b[1;31m"
+	}
+	return "\x1b[31m"
+}
+
+// appendStyle appends the decoration of a message of the given level to b,
+// according to the current style. add appends a string to b. Returns the index
+// within b at which the severity tag starts, or -1 if there is no tag. Must be
+// called while mu is held.
+func appendStyle(b []byte, level Level, add func([]byte, string) []byte) ([]byte, int) {
+	if style&Prefixed == 0 {
+		return b, -1
+	}
+	if prefix != "" {
+		b = add(b, prefix+": ")
+	}
+	tag := levelTag(level)
+	if tag == "" {
+		return b, -1
+	}
+	i := len(b)
+	return append(b, tag+" "...), i
+}
+
+// colored returns a copy of b where the severity tag at index i is colored, if
+// writes to w should be colored. Otherwise, b is returned. Must be called while
+// mu is held.
+func colored(w io.Writer, b []byte, level Level, i int) []byte {
+	if i < 0 || style&Color == 0 || noColor || os.Getenv("NO_COLOR") != "" || !isTerminal(w) {
+		return b
+	}
+	return colorTag(b, level, i)
+}
+
+// colorTag returns a copy of b where the severity tag of the given level at
+// index i is colored. Returns b if the tag is not at i.
+func colorTag(b []byte, level Level, i int) []byte {
+	tag := levelTag(level)
+	if i < 0 || i+len(tag) > len(b) || !bytes.HasPrefix(b[i:], []byte(tag)) {
+		// Removed by MaxLineWidth.
+		return b
+	}
+	c := make([]byte, 0, len(b)+16)
+	c = append(c, b[:i]...)
+	c = append(c, levelColor(level)...)
+	c = append(c, tag...)
+	c = append(c, "\x1b[0m"...)
+	return append(c, b[i+len(tag):]...)
+}
+
+// isTerminal returns whether w is a file that refers to a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	return ok && isTerminalFd(f.Fd())
+}
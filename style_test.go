@@ -0,0 +1,118 @@
+package but
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestIsTerminalDevNull(t *testing.T) {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Errorf("%s reported as a terminal", os.DevNull)
+	}
+}
+
+func TestStyle(t *testing.T) {
+	out, _ := capture(t)
+	prevPrefix := prefix
+	t.Cleanup(func() {
+		SetStyle(0)
+		SetPrefix(prevPrefix)
+	})
+	SetPrefix("tool")
+	agg := Errors{Msg: "m", Errs: []error{errors.New("a"), errors.New("b")}}
+	tests := []struct {
+		name  string
+		style Style
+		call  func()
+		want  string
+	}{
+		{"default", 0, func() { IfError(errors.New("x"), "ctx") }, "ctx: x\n"},
+		{"default Fatal", 0, func() { Fatal("stop") }, "stop\n"},
+		{"error", Prefixed, func() { IfError(errors.New("x"), "ctx") }, "tool: error: ctx: x\n"},
+		{"fatal", Prefixed, func() { Fatal("stop") }, "tool: fatal: stop\n"},
+		{"log", Prefixed, func() { Log("hello") }, "tool: hello\n"},
+		{"multi-line", Prefixed, func() { IfError(agg) }, "tool: error: m\n\ta\n\tb\n"},
+	}
+	for _, test := range tests {
+		out.Reset()
+		SetStyle(test.style)
+		test.call()
+		if got := withoutStack(out.String()); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAppendStyle(t *testing.T) {
+	prevPrefix := prefix
+	t.Cleanup(func() {
+		SetStyle(0)
+		SetPrefix(prevPrefix)
+	})
+	add := func(b []byte, s string) []byte { return append(b, s...) }
+	tests := []struct {
+		style  Style
+		prefix string
+		level  Level
+		want   string
+		tag    int
+	}{
+		{0, "tool", LevelError, "", -1},
+		{Prefixed, "tool", LevelError, "tool: error: ", 6},
+		{Prefixed, "tool", LevelFatal, "tool: fatal: ", 6},
+		{Prefixed, "tool", LevelInfo, "tool: ", -1},
+		{Prefixed, "", LevelError, "error: ", 0},
+		{Prefixed, "", LevelInfo, "", -1},
+	}
+	for _, test := range tests {
+		SetStyle(test.style)
+		SetPrefix(test.prefix)
+		mu.Lock()
+		b, tag := appendStyle(nil, test.level, add)
+		mu.Unlock()
+		if string(b) != test.want || tag != test.tag {
+			t.Errorf("style %d, prefix %q, level %v: got (%q, %d), want (%q, %d)",
+				test.style, test.prefix, test.level, b, tag, test.want, test.tag)
+		}
+	}
+}
+
+func TestColorTag(t *testing.T) {
+	tests := []struct {
+		b     string
+		level Level
+		i     int
+		want  string
+	}{
+		{"tool: error: x", LevelError, 6, "tool: \x1b[31merror:\x1b[0m x"},
+		{"fatal: x", LevelFatal, 0, "\x1b[1;31mfatal:\x1b[0m x"},
+		{"tool: error: x", LevelError, -1, "tool: error: x"},
+		{"tool: error: x", LevelError, 3, "tool: error: x"},
+		{"tool: err", LevelError, 6, "tool: err"},
+		{"tool: error: x", LevelError, 40, "tool: error: x"},
+	}
+	for _, test := range tests {
+		if got := string(colorTag([]byte(test.b), test.level, test.i)); got != test.want {
+			t.Errorf("colorTag(%q, %v, %d): got %q, want %q", test.b, test.level, test.i, got, test.want)
+		}
+	}
+}
+
+func TestColoredNotTerminal(t *testing.T) {
+	t.Cleanup(func() { SetStyle(0) })
+	SetStyle(Prefixed | Color)
+	b := []byte("tool: error: x")
+	mu.Lock()
+	got := colored(new(bytes.Buffer), b, LevelError, 6)
+	mu.Unlock()
+	if string(got) != string(b) {
+		t.Errorf("colored a non-terminal: got %q", got)
+	}
+}
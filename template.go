@@ -0,0 +1,50 @@
+package but
+
+import (
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// tmu guards templates.
+var tmu sync.RWMutex
+
+// templates contains the templates registered with RegisterTemplate.
+var templates = map[string]*template.Template{}
+
+// RegisterTemplate parses tmpl as a text/template, and registers it under name
+// for use with Tmpl. An existing template of the same name is replaced. Returns
+// an error if tmpl could not be parsed.
+func RegisterTemplate(name, tmpl string) error {
+	t, err := template.New(name).Parse(tmpl)
+	if err != nil {
+		return err
+	}
+	tmu.Lock()
+	defer tmu.Unlock()
+	templates[name] = t
+	return nil
+}
+
+// Tmpl executes the template registered under name with data, and prints the
+// result to Output as a line.
+//
+// If no such template is registered, or the template fails to execute, then
+// the error is printed instead, followed by data formatted with %v.
+func Tmpl(name string, data interface{}) {
+	tmu.RLock()
+	t := templates[name]
+	tmu.RUnlock()
+	if t == nil {
+		std.writef(LevelError, "template %q is not registered\n", name)
+		std.writef(LevelInfo, "%v\n", data)
+		return
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, data); err != nil {
+		std.writeErrorf(LevelError, err, "%s\n", formatError(err))
+		std.writef(LevelInfo, "%v\n", data)
+		return
+	}
+	std.writeln(LevelInfo, b.String())
+}
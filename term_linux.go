@@ -0,0 +1,14 @@
+package but
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// isTerminalFd returns whether fd refers to a terminal, by querying its
+// terminal attributes.
+func isTerminalFd(fd uintptr) bool {
+	var t syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TCGETS, uintptr(unsafe.Pointer(&t)))
+	return errno == 0
+}
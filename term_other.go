@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+
+package but
+
+// isTerminalFd reports false on systems where terminals cannot be detected, so
+// that Color is never used.
+func isTerminalFd(fd uintptr) bool {
+	return false
+}
@@ -0,0 +1,9 @@
+package but
+
+import "syscall"
+
+// isTerminalFd returns whether fd refers to a console.
+func isTerminalFd(fd uintptr) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}